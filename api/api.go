@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/entwico/helm-deployer/conf"
@@ -35,6 +36,8 @@ type ListResponse struct {
 	PageSize int         `json:"pageSize"`
 	Total    int         `json:"total"`
 	Items    interface{} `json:"items"`
+	// Next is the cursor of the next page, empty on the last page
+	Next string `json:"next,omitempty"`
 }
 
 // MessageResponse for REST API
@@ -125,15 +128,35 @@ func NewAPI(config *conf.Config, services *domain.Services) *API {
 	g.DELETE("/webhooks/:id", api.DeleteWebhook)
 	g.POST("/webhooks/:id/deploy", api.ForceDeploy)
 
+	// deployments
+	g.GET("/deployments", api.ListDeployments)
+	g.GET("/deployments/:id", api.GetDeployment)
+
+	// deploy queue
+	g.GET("/deploy-jobs", api.ListDeployJobs)
+	g.GET("/deploy-jobs/:id", api.GetDeployJob)
+	g.POST("/deploy-jobs/:id/retry", api.RetryDeployJob)
+	g.DELETE("/deploy-jobs/:id", api.DeleteDeployJob)
+
 	// releases
 	g.GET("/releases", api.ListReleases)
+	g.GET("/releases/:name", api.GetRelease)
+	g.GET("/releases/:name/history", api.GetReleaseHistory)
 	g.PUT("/releases/:name", api.UpdateRelease)
+	g.POST("/releases/:name/rollback", api.RollbackRelease)
+	g.DELETE("/releases/:name", api.DeleteRelease)
 
 	e.GET("/*", api.serveVirtualFS, api.frontend404Fallback)
 
 	return api
 }
 
+//isDryRun returns true if the request asks to only render the changes
+func isDryRun(c echo.Context) bool {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dryRun"))
+	return dryRun
+}
+
 func (api *API) setupRequest(f echo.HandlerFunc) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		req := ctx.Request()
@@ -11,7 +11,7 @@ import (
 func (api *API) ListChartItems(c echo.Context) error {
 	var err error
 
-	items, err := api.services.ChartRepositoryService.FindAllCharts(c.Request().Context())
+	items, err := api.services.ChartRepositoryRegistry.FindAllCharts(c.Request().Context())
 	if err != nil {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
 		return c.JSON(http.StatusInternalServerError, response)
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/entwico/helm-deployer/enums"
+	"github.com/labstack/echo"
+)
+
+//ListDeployJobs returns a list of queued deploy jobs, optionally filtered by status
+func (api *API) ListDeployJobs(c echo.Context) error {
+	items, err := api.services.DeployQueue.FindAll(c.QueryParam("status"))
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	response := &ListResponse{Page: 1, PageSize: len(items), Total: len(items), Items: items}
+	return c.JSON(http.StatusOK, response)
+}
+
+//GetDeployJob returns existing deploy job
+func (api *API) GetDeployJob(c echo.Context) error {
+	id := c.Param("id")
+	item, err := api.services.DeployQueue.FindOne(id)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	if item == nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: "item not found"}
+		return c.JSON(http.StatusNotFound, response)
+	}
+	return c.JSON(http.StatusOK, item)
+}
+
+//RetryDeployJob moves a dead deploy job back to the queue
+func (api *API) RetryDeployJob(c echo.Context) error {
+	id := c.Param("id")
+	item, err := api.services.DeployQueue.Retry(id)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusBadRequest, response)
+	}
+	return c.JSON(http.StatusOK, item)
+}
+
+//DeleteDeployJob removes deploy job from the queue
+func (api *API) DeleteDeployJob(c echo.Context) error {
+	id := c.Param("id")
+	err := api.services.DeployQueue.Delete(id)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	response := &MessageResponse{Message: "item deleted"}
+	return c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/entwico/helm-deployer/enums"
+	"github.com/globalsign/mgo/bson"
+	"github.com/labstack/echo"
+)
+
+const (
+	defaultDeploymentsPageSize = 100
+	maxDeploymentsPageSize     = 1000
+)
+
+//ListDeployments returns a page of Deployments filtered by release and status, newest first.
+//The next page starts after the Deployment with the id passed as "before"
+func (api *API) ListDeployments(c echo.Context) error {
+	limit := defaultDeploymentsPageSize
+	if value := c.QueryParam("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxDeploymentsPageSize {
+			response := &MessageResponse{Status: enums.StatusError, Message: fmt.Sprintf("limit must be between 1 and %d", maxDeploymentsPageSize)}
+			return c.JSON(http.StatusBadRequest, response)
+		}
+	}
+	filter := domain.DeploymentFilter{
+		ReleaseName: c.QueryParam("release"),
+		Status:      c.QueryParam("status"),
+		Before:      c.QueryParam("before"),
+		// one more item tells if there is a next page
+		Limit: limit + 1,
+	}
+	if filter.Before != "" && !bson.IsObjectIdHex(filter.Before) {
+		response := &MessageResponse{Status: enums.StatusError, Message: "invalid value of before"}
+		return c.JSON(http.StatusBadRequest, response)
+	}
+	items, err := api.services.DeploymentService.FindAll(filter)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	next := ""
+	if len(items) > limit {
+		items = items[:limit]
+		next = items[limit-1].ID.Hex()
+	}
+	total, err := api.services.DeploymentService.Count(filter)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	response := &ListResponse{Page: 1, PageSize: limit, Total: total, Items: items, Next: next}
+	return c.JSON(http.StatusOK, response)
+}
+
+//GetDeployment returns existing Deployment
+func (api *API) GetDeployment(c echo.Context) error {
+	id := c.Param("id")
+	item, err := api.services.DeploymentService.FindOne(id)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	if item == nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: "item not found"}
+		return c.JSON(http.StatusNotFound, response)
+	}
+	return c.JSON(http.StatusOK, item)
+}
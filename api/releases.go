@@ -2,6 +2,7 @@ package api
 
 import (
 	"net/http"
+	"strconv"
 
 	"github.com/entwico/helm-deployer/domain"
 	"github.com/labstack/echo"
@@ -20,6 +21,37 @@ func (api *API) ListReleases(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+//GetRelease returns the latest revision of the release with its manifest and values
+func (api *API) GetRelease(c echo.Context) error {
+	item, err := api.services.ReleaseService.GetRelease(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		response := &MessageResponse{Message: err.Error()}
+		if _, ok := err.(*domain.ReleaseNotFoundError); ok {
+			return c.JSON(http.StatusNotFound, response)
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	if item == nil {
+		response := &MessageResponse{Message: "item not found"}
+		return c.JSON(http.StatusNotFound, response)
+	}
+	return c.JSON(http.StatusOK, item)
+}
+
+//GetReleaseHistory returns all revisions of the release
+func (api *API) GetReleaseHistory(c echo.Context) error {
+	items, err := api.services.ReleaseService.ReleaseHistory(c.Request().Context(), c.Param("name"))
+	if err != nil {
+		response := &MessageResponse{Message: err.Error()}
+		if _, ok := err.(*domain.ReleaseNotFoundError); ok {
+			return c.JSON(http.StatusNotFound, response)
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	response := &ListResponse{Page: 1, PageSize: len(items), Total: len(items), Items: items}
+	return c.JSON(http.StatusOK, response)
+}
+
 //UpdateRelease updates release
 func (api *API) UpdateRelease(c echo.Context) error {
 	r := new(domain.ReleaseUpdateRequest)
@@ -30,6 +62,17 @@ func (api *API) UpdateRelease(c echo.Context) error {
 	if r.Name == "" {
 		r.Name = c.Param("name")
 	}
+	if isDryRun(c) {
+		diff, err := api.services.ReleaseService.DryRunUpdateRelease(c.Request().Context(), r)
+		if err != nil {
+			response := &MessageResponse{Message: err.Error()}
+			if _, ok := err.(*domain.ReleaseNotFoundError); ok {
+				return c.JSON(http.StatusNotFound, response)
+			}
+			return c.JSON(http.StatusInternalServerError, response)
+		}
+		return c.JSON(http.StatusOK, diff)
+	}
 	err := api.services.ReleaseService.UpdateRelease(c.Request().Context(), r)
 	if err != nil {
 		response := &MessageResponse{Message: err.Error()}
@@ -37,3 +80,43 @@ func (api *API) UpdateRelease(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, "ok")
 }
+
+//RollbackRelease rolls back release to the requested or the previous revision
+func (api *API) RollbackRelease(c echo.Context) error {
+	r := new(domain.ReleaseRollbackRequest)
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(r); err != nil {
+			response := &MessageResponse{Message: err.Error()}
+			return c.JSON(http.StatusBadRequest, response)
+		}
+	}
+	r.Name = c.Param("name")
+	err := api.services.ReleaseService.RollbackRelease(c.Request().Context(), r)
+	if err != nil {
+		response := &MessageResponse{Message: err.Error()}
+		if _, ok := err.(*domain.ReleaseNotFoundError); ok {
+			return c.JSON(http.StatusNotFound, response)
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	return c.JSON(http.StatusOK, "ok")
+}
+
+//DeleteRelease deletes release, query parameter purge removes its history as well
+func (api *API) DeleteRelease(c echo.Context) error {
+	r := &domain.ReleaseDeleteRequest{Name: c.Param("name")}
+	r.Purge, _ = strconv.ParseBool(c.QueryParam("purge"))
+	r.Force, _ = strconv.ParseBool(c.QueryParam("force"))
+	err := api.services.ReleaseService.DeleteRelease(c.Request().Context(), r)
+	if err != nil {
+		response := &MessageResponse{Message: err.Error()}
+		if _, ok := err.(*domain.ReleaseInUseError); ok {
+			return c.JSON(http.StatusConflict, response)
+		}
+		if _, ok := err.(*domain.ReleaseNotFoundError); ok {
+			return c.JSON(http.StatusNotFound, response)
+		}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	return c.JSON(http.StatusOK, "ok")
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/labstack/echo"
+)
+
+//fakeReleaseService fails release operations with err
+type fakeReleaseService struct {
+	domain.ReleaseService
+	err error
+}
+
+func (s *fakeReleaseService) DryRunUpdateRelease(ctx context.Context, r *domain.ReleaseUpdateRequest) (*domain.ReleaseDiff, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &domain.ReleaseDiff{}, nil
+}
+
+func (s *fakeReleaseService) DeleteRelease(ctx context.Context, r *domain.ReleaseDeleteRequest) error {
+	return s.err
+}
+
+func newTestReleasesAPI(err error) *API {
+	return &API{services: &domain.Services{ReleaseService: &fakeReleaseService{err: err}}}
+}
+
+//serveRelease calls the handler with the release name path parameter
+func serveRelease(handler echo.HandlerFunc, method, target, body string) (*httptest.ResponseRecorder, error) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("name")
+	c.SetParamValues("app")
+	return rec, handler(c)
+}
+
+func TestUpdateReleaseDryRunStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "diff", wantStatus: http.StatusOK},
+		{name: "unknown release", err: &domain.ReleaseNotFoundError{Release: "app"}, wantStatus: http.StatusNotFound},
+		{name: "failure", err: errors.New("tiller unavailable"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestReleasesAPI(tt.err)
+			rec, err := serveRelease(api.UpdateRelease, http.MethodPut, "/api/v1/releases/app?dryRun=true", `{"chartUrl": "http://charts/app-1.0.0.tgz"}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("UpdateRelease() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteReleaseStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus int
+	}{
+		{name: "deleted", wantStatus: http.StatusOK},
+		{name: "unknown release", err: &domain.ReleaseNotFoundError{Release: "app"}, wantStatus: http.StatusNotFound},
+		{name: "deployed by webhooks", err: &domain.ReleaseInUseError{Release: "app", Webhooks: []string{"app"}}, wantStatus: http.StatusConflict},
+		{name: "failure", err: errors.New("tiller unavailable"), wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestReleasesAPI(tt.err)
+			rec, err := serveRelease(api.DeleteRelease, http.MethodDelete, "/api/v1/releases/app?purge=true", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("DeleteRelease() status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
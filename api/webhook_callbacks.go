@@ -1,15 +1,17 @@
 package api
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
 	"github.com/entwico/helm-deployer/enums"
 	"github.com/labstack/echo"
 )
 
-//ProcessWebhook listens to webhooks
+//ProcessWebhook listens to webhooks and queues the deploys they trigger
 func (api *API) ProcessWebhook(c echo.Context) error {
 	data, err := ioutil.ReadAll(c.Request().Body)
 	if err != nil {
@@ -17,17 +19,45 @@ func (api *API) ProcessWebhook(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, response)
 	}
 	defer func() { _ = c.Request().Body.Close() }()
-	p, err := api.services.WebhookDispatcher.GetWebhookProcessor(c.Request().Context(), c.Request().Header, data)
+
+	ctx := c.Request().Context()
+	if name := c.Param("name"); name != "" {
+		w, err := api.services.WebhookService.FindByName(name)
+		if err != nil {
+			response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+			return c.JSON(http.StatusInternalServerError, response)
+		}
+		if w == nil {
+			response := &MessageResponse{Status: enums.StatusError, Message: fmt.Sprintf("webhook %s not found", name)}
+			return c.JSON(http.StatusNotFound, response)
+		}
+		ctx = domain.NewContextWithWebhook(ctx, w)
+	}
+
+	p, err := api.services.WebhookDispatcher.GetWebhookProcessor(ctx, c.Request().Header, data)
 	if err != nil {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
 		return c.JSON(http.StatusBadRequest, response)
 	}
+	if err := p.Verify(ctx, c.Request().Header, data); err != nil {
+		logger := logging.FromContext(ctx)
+		logger.WithField("error", err).Warn("could not verify webhook")
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusUnauthorized, response)
+	}
 
-	go func() {
-		if err := p.Process(c.Request().Context(), c.Request().Header, data); err != nil {
-			logger := logging.FromContext(c.Request().Context())
-			logger.WithField("error", err).Warn("could not process webhook")
-		}
-	}()
-	return c.JSON(http.StatusOK, &MessageResponse{Message: "ok"})
+	events, err := p.Process(ctx, c.Request().Header, data)
+	if err != nil {
+		logger := logging.FromContext(ctx)
+		logger.WithField("error", err).Warn("could not process webhook")
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusBadRequest, response)
+	}
+	// deploy jobs are persisted before the sender is told that the webhook is accepted
+	jobs, err := api.services.WebhookDispatcher.Dispatch(ctx, events)
+	if err != nil {
+		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		return c.JSON(http.StatusInternalServerError, response)
+	}
+	return c.JSON(http.StatusOK, &MessageResponse{Message: fmt.Sprintf("%d deploy jobs queued", len(jobs))})
 }
@@ -9,6 +9,17 @@ import (
 	"github.com/labstack/echo"
 )
 
+//webhookResponse is a Webhook without its secret, secrets are write-only
+type webhookResponse struct {
+	*domain.Webhook
+	Secret    string `json:"secret,omitempty"`
+	HasSecret bool   `json:"hasSecret"`
+}
+
+func newWebhookResponse(w *domain.Webhook) *webhookResponse {
+	return &webhookResponse{Webhook: w, HasSecret: w.Secret != ""}
+}
+
 //ListWebhooks returns a list of Webhook objects
 func (api *API) ListWebhooks(c echo.Context) error {
 	var err error
@@ -18,7 +29,11 @@ func (api *API) ListWebhooks(c echo.Context) error {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
 		return c.JSON(http.StatusInternalServerError, response)
 	}
-	response := &ListResponse{Page: 1, PageSize: len(items), Total: len(items), Items: items}
+	responses := make([]*webhookResponse, 0, len(items))
+	for i := range items {
+		responses = append(responses, newWebhookResponse(&items[i]))
+	}
+	response := &ListResponse{Page: 1, PageSize: len(items), Total: len(items), Items: responses}
 	return c.JSON(http.StatusOK, response)
 }
 
@@ -32,9 +47,12 @@ func (api *API) CreateWebhook(c echo.Context) error {
 	item, err := api.services.WebhookService.Create(item)
 	if err != nil {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		if _, ok := err.(*domain.WebhookNameInUseError); ok {
+			return c.JSON(http.StatusConflict, response)
+		}
 		return c.JSON(http.StatusInternalServerError, response)
 	}
-	return c.JSON(http.StatusCreated, item)
+	return c.JSON(http.StatusCreated, newWebhookResponse(item))
 
 }
 
@@ -51,7 +69,7 @@ func (api *API) GetWebhook(c echo.Context) error {
 		response := &MessageResponse{Status: enums.StatusError, Message: "item not found"}
 		return c.JSON(http.StatusNotFound, response)
 	}
-	return c.JSON(http.StatusOK, item)
+	return c.JSON(http.StatusOK, newWebhookResponse(item))
 
 }
 
@@ -66,9 +84,12 @@ func (api *API) UpdateWebhook(c echo.Context) error {
 	item, err := api.services.WebhookService.Update(id, newItem)
 	if err != nil {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+		if _, ok := err.(*domain.WebhookNameInUseError); ok {
+			return c.JSON(http.StatusConflict, response)
+		}
 		return c.JSON(http.StatusInternalServerError, response)
 	}
-	return c.JSON(http.StatusOK, item)
+	return c.JSON(http.StatusOK, newWebhookResponse(item))
 
 }
 
@@ -84,7 +105,7 @@ func (api *API) DeleteWebhook(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
-//ForceDeploy forces chart redeploy
+//ForceDeploy queues a forced chart redeploy, a dry run is rendered right away
 func (api *API) ForceDeploy(c echo.Context) error {
 	id := c.Param("id")
 	w, err := api.services.WebhookService.FindOne(id)
@@ -97,11 +118,24 @@ func (api *API) ForceDeploy(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, response)
 	}
 
-	err = api.services.HelmService.DeployChart(c.Request().Context(), w.DeployConfig)
+	event := domain.DeployEvent{
+		DeployConfig:   w.DeployConfig,
+		Trigger:        domain.TriggerManual,
+		TriggerSummary: fmt.Sprintf("forced deploy of webhook %s", w.Name),
+	}
+	if isDryRun(c) {
+		diff, err := api.services.DeploymentService.DryRun(c.Request().Context(), event.DeployConfig)
+		if err != nil {
+			response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
+			return c.JSON(http.StatusInternalServerError, response)
+		}
+		return c.JSON(http.StatusOK, diff)
+	}
+	job, err := api.services.DeployQueue.Enqueue(event)
 	if err != nil {
 		response := &MessageResponse{Status: enums.StatusError, Message: err.Error()}
 		return c.JSON(http.StatusInternalServerError, response)
 	}
-	response := &MessageResponse{Message: fmt.Sprintf("deploy for %s dispatched", w.DeployConfig.ReleaseName)}
+	response := &MessageResponse{Message: fmt.Sprintf("deploy job %s for %s queued", job.ID.Hex(), w.DeployConfig.ReleaseName)}
 	return c.JSON(http.StatusOK, response)
 }
@@ -0,0 +1,26 @@
+package api
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestWebhookResponseHidesSecret(t *testing.T) {
+	data, err := json.Marshal(newWebhookResponse(&domain.Webhook{Name: "app", Secret: "s3cr3t"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cr3t") {
+		t.Errorf("webhook response contains the secret: %s", data)
+	}
+	var res map[string]interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res["hasSecret"] != true || res["name"] != "app" {
+		t.Errorf("unexpected webhook response: %s", data)
+	}
+}
@@ -23,7 +23,7 @@ var rootCmd = &cobra.Command{
 	Use:   serviceName,
 	Short: "Redeploys helm charts",
 	Long: `helm-deployer
-listens to webhooks from GitLab/Nexus and redeploys helm-charts`,
+listens to webhooks from GitLab/GitHub/Nexus/Harbor/Docker Registry and redeploys helm-charts`,
 
 	Run: func(cmd *cobra.Command, args []string) {
 		executeWithConfig(serve)
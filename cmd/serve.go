@@ -41,8 +41,8 @@ func serve(config *conf.Config) {
 
 	go services.K8SReleaseProvider.Start()
 	go func() {
-		logger.Debug("start watching deploy config events")
-		services.WebhookDispatcher.StartHandleDeployConfigEvents(logging.NewContextWithLogger(context.Background(), logger))
+		logger.Debug("start processing deploy jobs")
+		services.DeployQueue.Start(logging.NewContextWithLogger(context.Background(), logger))
 	}()
 
 	apiServer := api.NewAPI(config, services)
@@ -73,22 +73,51 @@ func configureServices(config *conf.Config) (*domain.Services, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create chartValuesRepository")
 	}
+	deploymentRepository, err := service.NewDeploymentRepository(db)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create deploymentRepository")
+	}
+	deployJobRepository, err := service.NewDeployJobRepository(db)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create deployJobRepository")
+	}
 
 	k8SReleaseProvider, err := service.NewK8SReleaseProvider(config.K8S.ConfigPath, config.LogConfig.Logger)
 	if err != nil {
 		return nil, errors.Wrap(err, "could not create K8SReleaseProvider")
 	}
+	chartRepositoryRegistry, err := service.NewChartRepositoryRegistry(config.ChartRepositories, config.ChartCache.Path)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not create ChartRepositoryRegistry")
+	}
 	services := &domain.Services{
-		ChartValuesService:     service.NewChartValuesService(chartValuesRepository),
-		ChartRepositoryService: service.NewChartRepositoryService(config.ChartRepository.BaseURL),
-		K8SReleaseProvider:     k8SReleaseProvider,
-		WebhookService:         service.NewWebhookService(webhookRepository),
+		ChartValuesService:      service.NewChartValuesService(chartValuesRepository),
+		ChartRepositoryRegistry: chartRepositoryRegistry,
+		K8SReleaseProvider:      k8SReleaseProvider,
+		WebhookService:          service.NewWebhookService(webhookRepository, config.Helm.Backend),
+	}
+	switch config.Helm.Backend {
+	case conf.HelmBackendHelm3:
+		services.HelmService, err = service.NewHelm3Service(config.K8S.ConfigPath, services.ChartValuesService, services.ChartRepositoryRegistry, config.LogConfig.Logger)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not create HelmService")
+		}
+	default:
+		services.HelmService = service.NewHelmService(helm.NewClient(helm.Host(config.Tiller.Host)), services.ChartValuesService, services.ChartRepositoryRegistry)
 	}
-	services.HelmService = service.NewHelmService(helm.NewClient(helm.Host(config.Tiller.Host)), services.ChartValuesService, services.ChartRepositoryService)
-	nexusProcessor := service.NewNexusProcessor(k8SReleaseProvider, config.LogConfig.Logger)
-	gitlabProcessor := service.NewGitlabProcessor(services.WebhookService)
-	services.WebhookDispatcher = service.NewWebhookDispatcher(services.HelmService, []domain.WebhookProcessor{gitlabProcessor, nexusProcessor})
-	services.ReleaseService = service.NewReleaseService(services.HelmService)
+	services.DeploymentService = service.NewDeploymentService(deploymentRepository, services.HelmService, k8SReleaseProvider,
+		config.Rollout.Timeout, config.Deployments.Retention)
+	queueConfig := config.DeployQueue
+	services.DeployQueue = service.NewDeployQueue(deployJobRepository, services.DeploymentService,
+		queueConfig.Workers, queueConfig.MaxAttempts, queueConfig.InitialBackoff, queueConfig.MaxBackoff)
+	nexusProcessor := service.NewNexusProcessor(k8SReleaseProvider, config.Nexus.Secret, config.LogConfig.Logger)
+	registryProcessor := service.NewRegistryProcessor(k8SReleaseProvider, config.Registry.AuthHeader, config.LogConfig.Logger)
+	harborProcessor := service.NewHarborProcessor(k8SReleaseProvider, config.Harbor.AuthHeader, config.LogConfig.Logger)
+	gitlabProcessor := service.NewGitlabProcessor(services.WebhookService, config.Gitlab.Token)
+	githubProcessor := service.NewGithubProcessor(services.WebhookService, config.Github.Secret, config.Github.APIURL, config.Github.Token)
+	processors := []domain.WebhookProcessor{gitlabProcessor, githubProcessor, nexusProcessor, registryProcessor, harborProcessor}
+	services.WebhookDispatcher = service.NewWebhookDispatcher(services.DeployQueue, processors)
+	services.ReleaseService = service.NewReleaseService(services.HelmService, services.WebhookService)
 
 	return services, nil
 }
@@ -1,9 +1,55 @@
 package conf
 
 import (
+	"fmt"
+	"time"
+
 	log "github.com/sirupsen/logrus"
 )
 
+// Helm backends
+const (
+	HelmBackendTiller = "tiller"
+	HelmBackendHelm3  = "helm3"
+)
+
+// Chart repository types
+const (
+	// ChartRepositoryTypeChartMuseum lists charts through ChartMuseum API
+	ChartRepositoryTypeChartMuseum = "chartmuseum"
+	// ChartRepositoryTypeIndex lists charts from index.yaml of a static chart repository
+	ChartRepositoryTypeIndex = "index"
+	// ChartRepositoryTypeOCI pulls charts stored as OCI artifacts from a registry, url is oci://host
+	ChartRepositoryTypeOCI = "oci"
+)
+
+// DefaultChartRepositoryName is the name of the repository configured by chartRepository.baseUrl
+const DefaultChartRepositoryName = "default"
+
+// ChartRepository defines a chart repository and its credentials
+type ChartRepository struct {
+	Name string `mapstructure:"name"`
+	URL  string `mapstructure:"url"`
+	// chartmuseum if empty
+	Type string `mapstructure:"type"`
+	// basic auth
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	// bearer token auth
+	Token string `mapstructure:"token"`
+	// TLS client certificate auth
+	CAFile                string `mapstructure:"caFile"`
+	CertFile              string `mapstructure:"certFile"`
+	KeyFile               string `mapstructure:"keyFile"`
+	InsecureSkipTLSVerify bool   `mapstructure:"insecureSkipTlsVerify"`
+	// PGP keyring verifying chart provenance files
+	Keyring string `mapstructure:"keyring"`
+	// refuse charts without a valid provenance file
+	Verify bool `mapstructure:"verify"`
+	// hosts of OCI token servers receiving the credentials besides the registry itself
+	TokenRealmHosts []string `mapstructure:"tokenRealmHosts"`
+}
+
 // Config the application's configuration
 type Config struct {
 	API struct {
@@ -16,14 +62,56 @@ type Config struct {
 		Password string `mapstructure:"password"`
 	} `mapstructure:"app"`
 
+	// ChartRepository is the single repository used if no ChartRepositories are configured
 	ChartRepository struct {
 		BaseURL string `mapstructure:"baseUrl"`
 	} `mapstructure:"chartRepository"`
 
+	ChartRepositories []ChartRepository `mapstructure:"chartRepositories"`
+
+	// ChartCache stores downloaded chart archives, archives are not cached if path is empty
+	ChartCache struct {
+		Path string `mapstructure:"path"`
+	} `mapstructure:"chartCache"`
+
 	DB struct {
 		Path string `mapstructure:"path"`
 	} `mapstructure:"db"`
 
+	// Deployments keeps the deploy history, deployments older than retention are removed, they are kept forever if retention is negative
+	Deployments struct {
+		Retention time.Duration `mapstructure:"retention"`
+	} `mapstructure:"deployments"`
+
+	DeployQueue struct {
+		Workers        int           `mapstructure:"workers"`
+		MaxAttempts    int           `mapstructure:"maxAttempts"`
+		InitialBackoff time.Duration `mapstructure:"initialBackoff"`
+		MaxBackoff     time.Duration `mapstructure:"maxBackoff"`
+	} `mapstructure:"deployQueue"`
+
+	Github struct {
+		Secret string `mapstructure:"secret"`
+		// APIURL is used to look up tags of workflow runs, https://api.github.com if empty
+		APIURL string `mapstructure:"apiUrl"`
+		// Token authenticates GitHub API requests, private repositories require it
+		Token string `mapstructure:"token"`
+	} `mapstructure:"github"`
+
+	Gitlab struct {
+		Token string `mapstructure:"token"`
+	} `mapstructure:"gitlab"`
+
+	Harbor struct {
+		AuthHeader string `mapstructure:"authHeader"`
+	} `mapstructure:"harbor"`
+
+	// Helm selects the backend managing releases. The helm3 backend does not run chart hooks,
+	// webhooks are saved only if they disable hooks
+	Helm struct {
+		Backend string `mapstructure:"backend"`
+	} `mapstructure:"helm"`
+
 	K8S struct {
 		ConfigPath string `configPath:"host"`
 	} `mapstructure:"k8s"`
@@ -35,6 +123,19 @@ type Config struct {
 		Logger    *log.Entry
 	} `mapstructure:"log_config"`
 
+	Nexus struct {
+		Secret string `mapstructure:"secret"`
+	} `mapstructure:"nexus"`
+
+	Registry struct {
+		// AuthHeader is the Authorization header of notifications sent to the global route
+		AuthHeader string `mapstructure:"authHeader"`
+	} `mapstructure:"registry"`
+
+	Rollout struct {
+		Timeout time.Duration `mapstructure:"timeout"`
+	} `mapstructure:"rollout"`
+
 	Tiller struct {
 		Host string `mapstructure:"host"`
 	} `mapstructure:"tiller"`
@@ -48,6 +149,73 @@ func (c *Config) ValidateConfig() error {
 	if c.API.Host == "" {
 		c.API.Host = "localhost"
 	}
+	if c.DeployQueue.Workers == 0 {
+		c.DeployQueue.Workers = 4
+	}
+	if c.DeployQueue.MaxAttempts == 0 {
+		c.DeployQueue.MaxAttempts = 5
+	}
+	if c.DeployQueue.InitialBackoff == 0 {
+		c.DeployQueue.InitialBackoff = 10 * time.Second
+	}
+	if c.DeployQueue.MaxBackoff == 0 {
+		c.DeployQueue.MaxBackoff = 10 * time.Minute
+	}
+	if c.DeployQueue.Workers <= 0 || c.DeployQueue.MaxAttempts <= 0 {
+		return fmt.Errorf("deploy queue workers and maxAttempts must be positive")
+	}
+	if c.DeployQueue.InitialBackoff < 0 || c.DeployQueue.MaxBackoff < 0 {
+		return fmt.Errorf("deploy queue backoffs must not be negative")
+	}
+	if c.Deployments.Retention == 0 {
+		c.Deployments.Retention = 30 * 24 * time.Hour
+	}
+	if c.Deployments.Retention < 0 {
+		c.Deployments.Retention = 0
+	}
+	if c.Rollout.Timeout == 0 {
+		c.Rollout.Timeout = 5 * time.Minute
+	}
+	if c.Github.APIURL == "" {
+		c.Github.APIURL = "https://api.github.com"
+	}
+	if len(c.ChartRepositories) == 0 && c.ChartRepository.BaseURL != "" {
+		c.ChartRepositories = []ChartRepository{{Name: DefaultChartRepositoryName, URL: c.ChartRepository.BaseURL}}
+	}
+	names := make(map[string]bool)
+	for i := range c.ChartRepositories {
+		repo := &c.ChartRepositories[i]
+		if repo.Name == "" || repo.URL == "" {
+			return fmt.Errorf("chart repository name and url are required")
+		}
+		if names[repo.Name] {
+			return fmt.Errorf("chart repository '%s' is configured more than once", repo.Name)
+		}
+		names[repo.Name] = true
+		if repo.Token != "" && repo.Username != "" {
+			return fmt.Errorf("chart repository '%s' can not use both basic auth and token", repo.Name)
+		}
+		if (repo.CertFile == "") != (repo.KeyFile == "") {
+			return fmt.Errorf("chart repository '%s' requires both certFile and keyFile", repo.Name)
+		}
+		if repo.Verify && repo.Keyring == "" {
+			return fmt.Errorf("chart repository '%s' requires keyring to verify charts", repo.Name)
+		}
+		switch repo.Type {
+		case "":
+			repo.Type = ChartRepositoryTypeChartMuseum
+		case ChartRepositoryTypeChartMuseum, ChartRepositoryTypeIndex, ChartRepositoryTypeOCI:
+		default:
+			return fmt.Errorf("chart repository '%s' has unsupported type '%s'", repo.Name, repo.Type)
+		}
+	}
+	switch c.Helm.Backend {
+	case "":
+		c.Helm.Backend = HelmBackendTiller
+	case HelmBackendTiller, HelmBackendHelm3:
+	default:
+		return fmt.Errorf("helm backend '%s' not supported", c.Helm.Backend)
+	}
 
 	return nil
 }
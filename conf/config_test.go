@@ -0,0 +1,31 @@
+package conf
+
+import "testing"
+
+func TestValidateConfigDeployQueue(t *testing.T) {
+	tests := []struct {
+		name        string
+		workers     int
+		maxAttempts int
+		wantErr     bool
+	}{
+		{name: "defaults"},
+		{name: "configured", workers: 2, maxAttempts: 3},
+		{name: "negative workers", workers: -1, wantErr: true},
+		{name: "negative max attempts", maxAttempts: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := new(Config)
+			c.DeployQueue.Workers = tt.workers
+			c.DeployQueue.MaxAttempts = tt.maxAttempts
+			err := c.ValidateConfig()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (c.DeployQueue.Workers <= 0 || c.DeployQueue.MaxAttempts <= 0) {
+				t.Errorf("ValidateConfig() left workers %d and max attempts %d", c.DeployQueue.Workers, c.DeployQueue.MaxAttempts)
+			}
+		})
+	}
+}
@@ -14,10 +14,19 @@ type ChartRepositoryItem struct {
 	Urls        []string  `json:"urls"`
 	Created     time.Time `json:"created"`
 	Digest      string    `json:"digest"`
+	// Name of the chart repository serving the chart
+	Repository string `json:"repository,omitempty"`
 }
 
 //ChartRepositoryService interface
 type ChartRepositoryService interface {
 	FindAllCharts(ctx context.Context) ([]ChartRepositoryItem, error)
-	GetChartData(ctx context.Context, chartName, chartVersion string) ([]byte, error)
+	ResolveChart(ctx context.Context, chartName, versionConstraint string) (*ChartRepositoryItem, error)
+	GetChartData(ctx context.Context, chart *ChartRepositoryItem, verify bool) ([]byte, error)
+}
+
+//ChartRepositoryRegistry gives access to the configured chart repositories
+type ChartRepositoryRegistry interface {
+	FindAllCharts(ctx context.Context) ([]ChartRepositoryItem, error)
+	Repository(name, chartName string) (ChartRepositoryService, error)
 }
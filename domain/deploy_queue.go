@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"context"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+//DeployJob statuses
+const (
+	DeployJobStatusPending = "pending"
+	DeployJobStatusRunning = "running"
+	DeployJobStatusDead    = "dead"
+)
+
+//DeployJob is a queued DeployEvent
+type DeployJob struct {
+	ID bson.ObjectId `json:"id"`
+	DeployEvent
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"lastError,omitempty"`
+	MergedEvents  int       `json:"mergedEvents"`
+	NextAttemptAt time.Time `json:"nextAttemptAt"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+//DeployQueue persists deploy jobs and runs them with retries.
+//Jobs of the same release run one at a time, events for a release with a pending job are merged into it
+type DeployQueue interface {
+	Start(ctx context.Context)
+	Enqueue(event DeployEvent) (*DeployJob, error)
+	FindAll(status string) ([]DeployJob, error)
+	FindOne(id string) (*DeployJob, error)
+	Retry(id string) (*DeployJob, error)
+	Delete(id string) error
+}
+
+//DeployJobRepository persists DeployJobs to the database
+type DeployJobRepository interface {
+	FindAll() ([]DeployJob, error)
+	FindOne(id string) (*DeployJob, error)
+	Save(item *DeployJob) (*DeployJob, error)
+	Delete(id string) error
+}
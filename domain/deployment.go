@@ -0,0 +1,106 @@
+package domain
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+//Deployment trigger sources
+const (
+	TriggerGithub   = "github"
+	TriggerGitlab   = "gitlab"
+	TriggerHarbor   = "harbor"
+	TriggerManual   = "manual"
+	TriggerNexus    = "nexus"
+	TriggerRegistry = "registry"
+)
+
+//Deployment statuses
+const (
+	DeploymentStatusRunning = "running"
+	DeploymentStatusSuccess = "success"
+	DeploymentStatusFailed  = "failed"
+)
+
+//Deployment records a single deploy of a chart
+type Deployment struct {
+	ID              bson.ObjectId `json:"id"`
+	ReleaseName     string        `json:"releaseName"`
+	ChartName       string        `json:"chartName"`
+	ChartVersion    string        `json:"chartVersion"`
+	ChartValuesID   *string       `json:"chartValuesId"`
+	Trigger         string        `json:"trigger"`
+	PayloadSummary  string        `json:"payloadSummary,omitempty"`
+	Status          string        `json:"status"`
+	Error           string        `json:"error,omitempty"`
+	Revision        int32         `json:"revision,omitempty"`
+	RolledBackTo    int32         `json:"rolledBackTo,omitempty"`
+	RollbackError   string        `json:"rollbackError,omitempty"`
+	RolloutFailures []string      `json:"rolloutFailures,omitempty"`
+	TestResults     []TestResult  `json:"testResults,omitempty"`
+	StartedAt       time.Time     `json:"startedAt"`
+	FinishedAt      *time.Time    `json:"finishedAt,omitempty"`
+}
+
+//DeployResult describes the outcome of a chart deploy
+type DeployResult struct {
+	ChartVersion string `json:"chartVersion"`
+	// PreviousRevision is the revision deployed before the deploy started
+	PreviousRevision int32 `json:"previousRevision,omitempty"`
+	// Revision is the release revision created by the deploy, zero if the release was not touched
+	Revision int32 `json:"revision,omitempty"`
+	// Namespace the release is installed in, empty if the release was not touched
+	Namespace string `json:"namespace,omitempty"`
+}
+
+//TestResult is the outcome of a single test hook of the release
+type TestResult struct {
+	Name        string     `json:"name"`
+	Status      string     `json:"status"`
+	Info        string     `json:"info,omitempty"`
+	Logs        string     `json:"logs,omitempty"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+//RolloutError is returned when Deployments of a release are not rolled out in time
+type RolloutError struct {
+	Release string
+	Reasons []string
+}
+
+func (e *RolloutError) Error() string {
+	return fmt.Sprintf("release '%s' was not rolled out: %s", e.Release, strings.Join(e.Reasons, "; "))
+}
+
+//DeploymentFilter limits the list of Deployments, empty fields match any value
+type DeploymentFilter struct {
+	ReleaseName string
+	Status      string
+	// Before is the id of the last Deployment of the previous page, only older Deployments are returned
+	Before string
+	// Limit is the maximum number of Deployments returned, all Deployments are returned if zero
+	Limit int
+}
+
+//DeploymentService deploys charts and keeps deployment history
+type DeploymentService interface {
+	FindAll(filter DeploymentFilter) ([]Deployment, error)
+	Count(filter DeploymentFilter) (int, error)
+	FindOne(id string) (*Deployment, error)
+	Deploy(ctx context.Context, event DeployEvent) (*Deployment, error)
+	DryRun(ctx context.Context, cfg DeployConfig) (*ReleaseDiff, error)
+}
+
+//DeploymentRepository persists Deployments to the database
+type DeploymentRepository interface {
+	FindAll(filter DeploymentFilter) ([]Deployment, error)
+	Count(filter DeploymentFilter) (int, error)
+	FindOne(id string) (*Deployment, error)
+	Save(item *Deployment) (*Deployment, error)
+	DeleteStartedBefore(t time.Time) (int, error)
+}
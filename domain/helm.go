@@ -4,12 +4,20 @@ import (
 	"context"
 	"io"
 
+	"k8s.io/helm/pkg/proto/hapi/release"
 	"k8s.io/helm/pkg/proto/hapi/services"
 )
 
 //HelmService interface
 type HelmService interface {
 	ListReleases(ctx context.Context) (*services.ListReleasesResponse, error)
+	ReleaseContent(ctx context.Context, rlsName string) (*services.GetReleaseContentResponse, error)
+	ReleaseHistory(ctx context.Context, rlsName string) (*services.GetHistoryResponse, error)
 	UpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error)
-	DeployChart(ctx context.Context, cfg DeployConfig) error
+	DryRunUpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error)
+	DeployChart(ctx context.Context, cfg DeployConfig, imageTag string) (*DeployResult, error)
+	DryRunDeployChart(ctx context.Context, cfg DeployConfig) (*services.UpdateReleaseResponse, error)
+	RollbackRelease(ctx context.Context, rlsName string, version int32, options *UpgradeOptions) (*services.RollbackReleaseResponse, error)
+	DeleteRelease(ctx context.Context, rlsName string, purge bool) (*services.UninstallReleaseResponse, error)
+	RunReleaseTest(ctx context.Context, rlsName string, timeout int64) (*release.Release, error)
 }
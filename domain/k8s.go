@@ -1,7 +1,14 @@
 package domain
 
+import (
+	"context"
+	"time"
+)
+
 //K8SReleaseProvider interface
 type K8SReleaseProvider interface {
 	Start()
 	GetDeployConfigsForImagePath(path string) ([]*DeployConfig, error)
+	WaitForRollout(ctx context.Context, releaseName, namespace string, timeout time.Duration) error
+	GetPodLogs(namespace, name string) (string, error)
 }
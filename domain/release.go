@@ -2,6 +2,8 @@ package domain
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -12,6 +14,51 @@ type ReleaseUpdateRequest struct {
 	Values   string `json:"values"`
 }
 
+//ReleaseRollbackRequest struct
+type ReleaseRollbackRequest struct {
+	Name string `json:"name"`
+	// Revision to roll back to, zero means the previous one
+	Version int32 `json:"version"`
+}
+
+//ReleaseDeleteRequest struct
+type ReleaseDeleteRequest struct {
+	Name string `json:"name"`
+	// Remove release history as well
+	Purge bool `json:"purge"`
+	// Delete the release even if webhooks still deploy it
+	Force bool `json:"force"`
+}
+
+//ReleaseInUseError is returned when deleting a release which is still deployed by webhooks
+type ReleaseInUseError struct {
+	Release  string
+	Webhooks []string
+}
+
+func (e *ReleaseInUseError) Error() string {
+	return fmt.Sprintf("release '%s' is deployed by webhooks %s, use force to delete it", e.Release, strings.Join(e.Webhooks, ", "))
+}
+
+//ReleaseNotFoundError is returned when the release does not exist
+type ReleaseNotFoundError struct {
+	Release string
+}
+
+func (e *ReleaseNotFoundError) Error() string {
+	return fmt.Sprintf("release '%s' not found", e.Release)
+}
+
+//ReleaseDiff compares the manifest rendered by a dry-run upgrade with the deployed one
+type ReleaseDiff struct {
+	Name string `json:"name"`
+	// Deployed revision the rendered manifest is compared with, zero if there is none
+	Revision     int    `json:"revision"`
+	ChartVersion string `json:"chartVersion"`
+	// Unified diff of the manifests, empty if nothing changes
+	Diff string `json:"diff"`
+}
+
 //Release struct
 type Release struct {
 	Name      string              `json:"name"`
@@ -21,6 +68,8 @@ type Release struct {
 	Info      *ReleaseInfo        `json:"info,omitempty"`
 	Chart     *ReleaseChart       `json:"chart,omitempty"`
 	Config    *ReleaseChartConfig `json:"config,omitempty"`
+	// Chart values merged with the user supplied config
+	ComputedValues *ReleaseChartValues `json:"computedValues,omitempty"`
 }
 
 //ReleaseInfo struct
@@ -86,5 +135,10 @@ type ReleaseChartConfig struct {
 //ReleaseService manages Releases
 type ReleaseService interface {
 	ListReleases(ctx context.Context) ([]Release, error)
+	GetRelease(ctx context.Context, name string) (*Release, error)
+	ReleaseHistory(ctx context.Context, name string) ([]Release, error)
 	UpdateRelease(ctx context.Context, r *ReleaseUpdateRequest) error
+	DryRunUpdateRelease(ctx context.Context, r *ReleaseUpdateRequest) (*ReleaseDiff, error)
+	RollbackRelease(ctx context.Context, r *ReleaseRollbackRequest) error
+	DeleteRelease(ctx context.Context, r *ReleaseDeleteRequest) error
 }
@@ -2,11 +2,13 @@ package domain
 
 // Services used by the API
 type Services struct {
-	ChartValuesService     ChartValuesService
-	ChartRepositoryService ChartRepositoryService
-	HelmService            HelmService
-	K8SReleaseProvider     K8SReleaseProvider
-	ReleaseService         ReleaseService
-	WebhookService         WebhookService
-	WebhookDispatcher      WebhookDispatcher
+	ChartValuesService      ChartValuesService
+	ChartRepositoryRegistry ChartRepositoryRegistry
+	DeploymentService       DeploymentService
+	DeployQueue             DeployQueue
+	HelmService             HelmService
+	K8SReleaseProvider      K8SReleaseProvider
+	ReleaseService          ReleaseService
+	WebhookService          WebhookService
+	WebhookDispatcher       WebhookDispatcher
 }
@@ -2,6 +2,7 @@ package domain
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"net/http"
@@ -14,12 +15,34 @@ type Webhook struct {
 	ID           bson.ObjectId          `json:"id"`
 	Name         string                 `json:"name"`
 	Description  string                 `json:"description,omitempty"`
+	Secret       string                 `json:"secret,omitempty"`
+	Source       string                 `json:"source,omitempty"`
 	Condition    GitlabWebhookCondition `json:"condition"`
 	DeployConfig DeployConfig           `json:"deployConfig"`
 	CreatedAt    time.Time              `json:"createdAt"`
 	UpdatedAt    time.Time              `json:"updatedAt"`
 }
 
+//WebhookNameInUseError is returned when saving a Webhook with the name of another Webhook
+type WebhookNameInUseError struct {
+	Name string
+}
+
+func (e *WebhookNameInUseError) Error() string {
+	return fmt.Sprintf("webhook name '%s' is already in use", e.Name)
+}
+
+//Condition match modes. In glob and regex modes empty condition fields match any value,
+//exact mode compares all fields including empty ones
+const (
+	//MatchModeExact requires fields to be equal
+	MatchModeExact = "exact"
+	//MatchModeGlob treats fields as glob patterns with '/' as separator
+	MatchModeGlob = "glob"
+	//MatchModeRegex treats fields as regular expressions matching the whole value
+	MatchModeRegex = "regex"
+)
+
 //GitlabWebhookCondition defines webhook condition structure
 type GitlabWebhookCondition struct {
 	WebhookType      string `json:"webhookType"`
@@ -27,6 +50,7 @@ type GitlabWebhookCondition struct {
 	ProjectNamespace string `json:"projectNamespace"`
 	GitRef           string `json:"gitRef"`
 	IsTag            bool   `json:"isTag"`
+	MatchMode        string `json:"matchMode,omitempty"`
 }
 
 //DeployConfig defines deploy config structure
@@ -35,12 +59,77 @@ type DeployConfig struct {
 	ChartName     string  `json:"chartName"`
 	ChartVersion  string  `json:"chartVersion"`
 	ChartValuesID *string `json:"chartValuesId"`
+	// Name of the chart repository, the first configured repository if empty
+	Repository string `json:"repository,omitempty"`
+	// Refuse charts without a valid provenance file signed by a key of the repository keyring
+	Verify bool `json:"verify,omitempty"`
+	// Namespace the release is installed into, "default" if empty
+	Namespace string `json:"namespace,omitempty"`
+	// Install the release if it does not exist yet
+	InstallIfMissing bool `json:"installIfMissing,omitempty"`
+	// Dot separated path of the chart value receiving the image tag, e.g. image.tag
+	ImageValuePath string `json:"imageValuePath,omitempty"`
+	// Roll back to the previous revision if the deploy fails
+	Atomic bool `json:"atomic,omitempty"`
+	// Options passed to Helm on upgrade, DefaultUpgradeOptions are used if empty
+	UpgradeOptions *UpgradeOptions `json:"upgradeOptions,omitempty"`
+	// Run test hooks of the chart after a successful deploy, tests are skipped if empty
+	Tests *TestOptions `json:"tests,omitempty"`
+}
+
+//DeployEvent requests a deploy of the DeployConfig, it carries details of the triggering event
+type DeployEvent struct {
+	DeployConfig DeployConfig `json:"deployConfig"`
+	// Image tag taken from the triggering event
+	ImageTag string `json:"imageTag,omitempty"`
+	// Source of the triggering event, see Trigger* constants
+	Trigger string `json:"trigger,omitempty"`
+	// Short description of the triggering event
+	TriggerSummary string `json:"triggerSummary,omitempty"`
+}
+
+//UpgradeOptions control how Helm upgrades a release
+type UpgradeOptions struct {
+	// Force resource updates through delete and recreate if needed
+	Force bool `json:"force"`
+	// Restart pods of the release
+	Recreate bool `json:"recreate"`
+	// Wait until all resources are ready
+	Wait bool `json:"wait"`
+	// Timeout in seconds for Kubernetes operations and waiting
+	Timeout int64 `json:"timeout,omitempty"`
+	// Reset values to the ones built into the chart
+	ResetValues bool `json:"resetValues"`
+	// Reuse values of the deployed release and merge the new ones over them
+	ReuseValues bool `json:"reuseValues"`
+	// Do not run chart hooks
+	DisableHooks bool `json:"disableHooks"`
+}
+
+//TestOptions control how test hooks of the chart are run after a deploy
+type TestOptions struct {
+	// Timeout in seconds for each test
+	Timeout int64 `json:"timeout,omitempty"`
+	// Roll back to the previous revision if tests fail
+	Rollback bool `json:"rollback"`
+}
+
+//DefaultUpgradeOptions are used for DeployConfigs without upgrade options
+var DefaultUpgradeOptions = UpgradeOptions{Force: true, Recreate: true}
+
+//GetUpgradeOptions returns upgrade options of the DeployConfig, falling back to DefaultUpgradeOptions
+func (c DeployConfig) GetUpgradeOptions() UpgradeOptions {
+	if c.UpgradeOptions == nil {
+		return DefaultUpgradeOptions
+	}
+	return *c.UpgradeOptions
 }
 
 //WebhookService manages WebHooks
 type WebhookService interface {
 	FindAll() ([]Webhook, error)
 	FindOne(id string) (*Webhook, error)
+	FindByName(name string) (*Webhook, error)
 	Create(item *Webhook) (*Webhook, error)
 	Update(id string, newItem *Webhook) (*Webhook, error)
 	Delete(id string) error
@@ -55,17 +144,39 @@ type WebhookRepository interface {
 	Delete(id string) error
 }
 
+type webhookKeyType int
+
+const webhookKey webhookKeyType = iota
+
+//NewContextWithWebhook returns new Context with attached Webhook.
+//Processors limit verification and matching to the attached Webhook
+func NewContextWithWebhook(ctx context.Context, w *Webhook) context.Context {
+	return context.WithValue(ctx, webhookKey, w)
+}
+
+//WebhookFromContext returns Webhook attached to Context or nil
+func WebhookFromContext(ctx context.Context) *Webhook {
+	if ctx == nil {
+		return nil
+	}
+	if w, ok := ctx.Value(webhookKey).(*Webhook); ok {
+		return w
+	}
+	return nil
+}
+
 //WebhookDispatcher handles webhooks
 type WebhookDispatcher interface {
 	GetWebhookProcessor(ctx context.Context, headers http.Header, body []byte) (WebhookProcessor, error)
-	StartHandleDeployConfigEvents(ctx context.Context)
+	Dispatch(ctx context.Context, events []DeployEvent) ([]DeployJob, error)
 }
 
 //WebhookProcessor listens to Webhooks and deploys charts
 type WebhookProcessor interface {
 	//DetermineWebhookType(headers http.Header) (enums.WebhookType, error)
 	//DeployChart(cfg DeployConfig) error
+	Source() string
 	CanProcess(ctx context.Context, headers http.Header, body []byte) bool
-	Process(ctx context.Context, headers http.Header, body []byte) error
-	GetDeployConfigEvents(ctx context.Context) chan DeployConfig
+	Verify(ctx context.Context, headers http.Header, body []byte) error
+	Process(ctx context.Context, headers http.Header, body []byte) ([]DeployEvent, error)
 }
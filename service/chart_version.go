@@ -0,0 +1,59 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/Masterminds/semver"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+)
+
+const chartVersionLatest = "latest"
+
+//constraintSeparator matches whitespace between two constraints, e.g. ">=2.0.0 <3"
+var constraintSeparator = regexp.MustCompile(`([\dxX*])\s+([<>=!~^\d])`)
+
+//resolveChartVersion returns the chart with the highest version satisfying the constraint.
+//An exact version, a semver constraint or "latest" (highest stable version) are accepted
+func resolveChartVersion(charts []domain.ChartRepositoryItem, chartName, versionConstraint string) (*domain.ChartRepositoryItem, error) {
+	candidates := make([]domain.ChartRepositoryItem, 0)
+	for _, chart := range charts {
+		if chart.Name != chartName {
+			continue
+		}
+		if chart.Version == versionConstraint {
+			return &chart, nil
+		}
+		candidates = append(candidates, chart)
+	}
+	if len(candidates) == 0 {
+		return nil, errors.New("chart not found")
+	}
+
+	check := func(v *semver.Version) bool { return v.Prerelease() == "" }
+	if versionConstraint != "" && versionConstraint != chartVersionLatest {
+		constraint, err := semver.NewConstraint(constraintSeparator.ReplaceAllString(versionConstraint, "$1,$2"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid chart version constraint '%s': %v", versionConstraint, err)
+		}
+		check = constraint.Check
+	}
+
+	var result *domain.ChartRepositoryItem
+	var resultVersion *semver.Version
+	for i, chart := range candidates {
+		v, err := semver.NewVersion(chart.Version)
+		if err != nil || !check(v) {
+			continue
+		}
+		if resultVersion == nil || v.GreaterThan(resultVersion) {
+			result = &candidates[i]
+			resultVersion = v
+		}
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no version of chart %s matches '%s'", chartName, versionConstraint)
+	}
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestResolveChartVersion(t *testing.T) {
+	charts := []domain.ChartRepositoryItem{
+		{Name: "backend", Version: "1.0.0"},
+		{Name: "backend", Version: "1.2.0"},
+		{Name: "backend", Version: "1.10.1"},
+		{Name: "backend", Version: "2.0.0-rc.1"},
+		{Name: "backend", Version: "2.0.0"},
+		{Name: "backend", Version: "3.0.0-beta.2"},
+		{Name: "backend", Version: "dev"},
+		{Name: "frontend", Version: "5.0.0"},
+	}
+	tests := []struct {
+		name       string
+		chart      string
+		constraint string
+		want       string
+		wantErr    bool
+	}{
+		{name: "exact", chart: "backend", constraint: "1.2.0", want: "1.2.0"},
+		{name: "exact non semver", chart: "backend", constraint: "dev", want: "dev"},
+		{name: "exact prerelease", chart: "backend", constraint: "2.0.0-rc.1", want: "2.0.0-rc.1"},
+		{name: "latest skips prereleases", chart: "backend", constraint: "latest", want: "2.0.0"},
+		{name: "empty is latest", chart: "backend", constraint: "", want: "2.0.0"},
+		{name: "tilde", chart: "backend", constraint: "~1.2", want: "1.2.0"},
+		{name: "caret compares numerically", chart: "backend", constraint: "^1.0.0", want: "1.10.1"},
+		{name: "wildcard", chart: "backend", constraint: "1.x", want: "1.10.1"},
+		{name: "range separated by space", chart: "backend", constraint: ">=1.1.0 <2.0.0", want: "1.10.1"},
+		{name: "range separated by comma", chart: "backend", constraint: ">=1.1.0, <1.5", want: "1.2.0"},
+		{name: "prerelease constraint", chart: "backend", constraint: ">=3.0.0-0", want: "3.0.0-beta.2"},
+		{name: "other chart", chart: "frontend", constraint: "^5", want: "5.0.0"},
+		{name: "no matching version", chart: "backend", constraint: "^4", wantErr: true},
+		{name: "unknown chart", chart: "database", constraint: "latest", wantErr: true},
+		{name: "invalid constraint", chart: "backend", constraint: ">>1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chart, err := resolveChartVersion(charts, tt.chart, tt.constraint)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveChartVersion() = %s, want error", chart.Version)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveChartVersion() error = %v", err)
+			}
+			if chart.Name != tt.chart || chart.Version != tt.want {
+				t.Errorf("resolveChartVersion() = %s-%s, want %s-%s", chart.Name, chart.Version, tt.chart, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+const (
+	digestPrefixSHA256 = "sha256:"
+	// checksumFileSuffix is appended to archive files to store the checksum of charts without digest
+	checksumFileSuffix = ".sha256"
+)
+
+//chartArchiveCache stores chart archives of a repository on disk addressed by chart name and version
+type chartArchiveCache struct {
+	dir string
+}
+
+//newChartArchiveCache returns the cache of the repository archives in cacheDir, caching is disabled if cacheDir is empty
+func newChartArchiveCache(cacheDir, repository string) *chartArchiveCache {
+	if cacheDir == "" {
+		return &chartArchiveCache{}
+	}
+	return &chartArchiveCache{dir: filepath.Join(cacheDir, cacheFileName(repository))}
+}
+
+//get returns the cached archive of the chart version. Archives are verified against the chart digest
+//or, for charts without digest, against the checksum stored with the archive. Archives failing verification are removed
+func (c *chartArchiveCache) get(chart *domain.ChartRepositoryItem) ([]byte, bool) {
+	path, ok := c.path(chart)
+	if !ok {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	digest := chart.Digest
+	if digest == "" {
+		sum, err := ioutil.ReadFile(path + checksumFileSuffix)
+		if err != nil {
+			_ = os.Remove(path)
+			return nil, false
+		}
+		digest = strings.TrimSpace(string(sum))
+	}
+	if verifyChartDigest(data, digest) != nil {
+		_ = os.Remove(path)
+		_ = os.Remove(path + checksumFileSuffix)
+		return nil, false
+	}
+	return data, true
+}
+
+//put stores the archive, charts without digest get the sha256 checksum of the archive stored next to it
+func (c *chartArchiveCache) put(chart *domain.ChartRepositoryItem, data []byte) error {
+	path, ok := c.path(chart)
+	if !ok {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if chart.Digest == "" {
+		sum := sha256.Sum256(data)
+		if err := writeFileAtomic(path+checksumFileSuffix, []byte(hex.EncodeToString(sum[:]))); err != nil {
+			return err
+		}
+	}
+	return writeFileAtomic(path, data)
+}
+
+//writeFileAtomic renames the written file into place so readers never see partial files
+func writeFileAtomic(path string, data []byte) error {
+	file, err := ioutil.TempFile(filepath.Dir(path), ".download-")
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+	return err
+}
+
+//path returns the file of the archive, false if caching is disabled or the chart has no name or version
+func (c *chartArchiveCache) path(chart *domain.ChartRepositoryItem) (string, bool) {
+	name, version := cacheFileName(chart.Name), cacheFileName(chart.Version)
+	if c.dir == "" || name == "" || version == "" {
+		return "", false
+	}
+	return filepath.Join(c.dir, name, version+".tgz"), true
+}
+
+//cacheFileName escapes the value for use as a single path element, empty if it can not be used
+func cacheFileName(value string) string {
+	value = url.PathEscape(value)
+	if value == "." || value == ".." {
+		return ""
+	}
+	return value
+}
+
+//verifyChartDigest checks that the sha256 hash of the data matches the digest
+func verifyChartDigest(data []byte, digest string) error {
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if actual != normalizeDigest(digest) {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return nil
+}
+
+func normalizeDigest(digest string) string {
+	return strings.ToLower(strings.TrimPrefix(digest, digestPrefixSHA256))
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func newTestChartArchiveCache(t *testing.T, repository string) *chartArchiveCache {
+	dir, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.RemoveAll(dir)
+	})
+	return newChartArchiveCache(dir, repository)
+}
+
+func chartDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return digestPrefixSHA256 + hex.EncodeToString(sum[:])
+}
+
+func TestChartArchiveCache(t *testing.T) {
+	data := []byte("chart archive")
+	tests := []struct {
+		name   string
+		stored *domain.ChartRepositoryItem
+		chart  *domain.ChartRepositoryItem
+		want   bool
+	}{
+		{
+			name:   "same digest",
+			stored: &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0", Digest: chartDigest(data)},
+			chart:  &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0", Digest: chartDigest(data)},
+			want:   true,
+		},
+		{
+			name:   "chart without digest",
+			stored: &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"},
+			chart:  &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"},
+			want:   true,
+		},
+		{
+			name:   "other version",
+			stored: &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"},
+			chart:  &domain.ChartRepositoryItem{Name: "app", Version: "1.0.1"},
+		},
+		{
+			name:   "other chart with same content",
+			stored: &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0", Digest: chartDigest(data)},
+			chart:  &domain.ChartRepositoryItem{Name: "api", Version: "1.0.0", Digest: chartDigest(data)},
+		},
+		{
+			name:   "digest mismatch",
+			stored: &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"},
+			chart:  &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0", Digest: chartDigest([]byte("republished archive"))},
+		},
+		{
+			name:   "oci chart",
+			stored: &domain.ChartRepositoryItem{Name: "oci://registry.example.com/team/app", Version: "1.0.0+build.1"},
+			chart:  &domain.ChartRepositoryItem{Name: "oci://registry.example.com/team/app", Version: "1.0.0+build.1"},
+			want:   true,
+		},
+		{
+			name:   "path traversal is not cached",
+			stored: &domain.ChartRepositoryItem{Name: "..", Version: "1.0.0"},
+			chart:  &domain.ChartRepositoryItem{Name: "..", Version: "1.0.0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newTestChartArchiveCache(t, "stable")
+			if err := cache.put(tt.stored, data); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := cache.get(tt.chart)
+			if ok != tt.want || (ok && string(got) != string(data)) {
+				t.Errorf("get() = %q, %v, want cached %v", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestChartArchiveCacheRepositories(t *testing.T) {
+	stable := newTestChartArchiveCache(t, "stable")
+	internal := newChartArchiveCache(filepath.Dir(stable.dir), "internal")
+	chart := &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"}
+	if err := stable.put(chart, []byte("chart archive")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := internal.get(chart); ok {
+		t.Error("get() returned an archive cached by another repository")
+	}
+}
+
+func TestChartArchiveCacheRemovesMismatchingArchive(t *testing.T) {
+	cache := newTestChartArchiveCache(t, "stable")
+	chart := &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"}
+	if err := cache.put(chart, []byte("chart archive")); err != nil {
+		t.Fatal(err)
+	}
+	chart.Digest = chartDigest([]byte("republished archive"))
+	if _, ok := cache.get(chart); ok {
+		t.Fatal("get() returned an archive not matching the digest")
+	}
+	path, _ := cache.path(chart)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("mismatching archive was kept, stat error = %v", err)
+	}
+}
+
+func TestChartArchiveCacheVerifiesArchivesWithoutDigest(t *testing.T) {
+	tests := []struct {
+		name string
+		// change modifies the cached files of the archive
+		change func(t *testing.T, path string)
+		want   bool
+	}{
+		{name: "unchanged archive", change: func(t *testing.T, path string) {}, want: true},
+		{name: "corrupted archive", change: func(t *testing.T, path string) {
+			if err := ioutil.WriteFile(path, []byte("corrupted archive"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "archive without checksum", change: func(t *testing.T, path string) {
+			if err := os.Remove(path + checksumFileSuffix); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := newTestChartArchiveCache(t, "stable")
+			chart := &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"}
+			if err := cache.put(chart, []byte("chart archive")); err != nil {
+				t.Fatal(err)
+			}
+			path, _ := cache.path(chart)
+			tt.change(t, path)
+			if _, ok := cache.get(chart); ok != tt.want {
+				t.Fatalf("get() cached = %v, want %v", ok, tt.want)
+			}
+			if _, err := os.Stat(path); !tt.want && !os.IsNotExist(err) {
+				t.Errorf("unverified archive was kept, stat error = %v", err)
+			}
+		})
+	}
+}
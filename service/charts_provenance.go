@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const provenanceFileSuffix = ".prov"
+
+//verifyProvenance downloads the provenance file stored next to the chart archive and checks it
+func (c *chartRepositoryClient) verifyProvenance(ctx context.Context, chart *domain.ChartRepositoryItem, data []byte) error {
+	if c.signatory == nil {
+		return errors.New("chart repository has no keyring configured")
+	}
+	chartURL, err := c.chartURL(chart)
+	if err != nil {
+		return err
+	}
+	logger := logging.FromContext(ctx)
+	logger.WithField("url", chartURL+provenanceFileSuffix).Debug("downloading chart provenance")
+	prov, err := c.getData(ctx, chartURL+provenanceFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "chart is not signed, could not download provenance file")
+	}
+	u, err := url.Parse(chartURL)
+	if err != nil {
+		return err
+	}
+	return c.checkProvenance(ctx, chart, path.Base(u.Path), data, prov)
+}
+
+//checkProvenance checks that the provenance file is signed by a key of the repository keyring and matches the chart archive
+func (c *chartRepositoryClient) checkProvenance(ctx context.Context, chart *domain.ChartRepositoryItem, archiveName string, data, prov []byte) error {
+	if c.signatory == nil {
+		return errors.New("chart repository has no keyring configured")
+	}
+	// provenance file lists the archive by its file name, so both are verified under their original names
+	dir, err := ioutil.TempDir("", "chart-provenance-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	chartPath := filepath.Join(dir, archiveName)
+	if err := ioutil.WriteFile(chartPath, data, 0600); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(chartPath+provenanceFileSuffix, prov, 0600); err != nil {
+		return err
+	}
+
+	verification, err := c.signatory.Verify(chartPath, chartPath+provenanceFileSuffix)
+	if err != nil {
+		return errors.Wrap(err, "chart signature is not valid")
+	}
+	signedBy := make([]string, 0)
+	for name := range verification.SignedBy.Identities {
+		signedBy = append(signedBy, name)
+	}
+	logging.FromContext(ctx).WithFields(log.Fields{
+		"chart_name":    chart.Name,
+		"chart_version": chart.Version,
+		"signed_by":     signedBy,
+	}).Info("chart provenance verified")
+	return nil
+}
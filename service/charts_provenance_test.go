@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	"golang.org/x/crypto/openpgp"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/provenance"
+)
+
+func newTestSignatory(t *testing.T) *provenance.Signatory {
+	entity, err := openpgp.NewEntity("helm-deployer", "test", "charts@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &provenance.Signatory{Entity: entity, KeyRing: openpgp.EntityList{entity}}
+}
+
+//newSignedTestChart returns the archive of chart app-1.0.0 and its provenance file signed by the signatory
+func newSignedTestChart(t *testing.T, signatory *provenance.Signatory) ([]byte, []byte) {
+	dir, err := ioutil.TempDir("", "chart")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path, err := chartutil.Save(&chart.Chart{Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"}}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prov, err := signatory.ClearSign(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, []byte(prov)
+}
+
+func TestCheckProvenance(t *testing.T) {
+	signatory := newTestSignatory(t)
+	data, prov := newSignedTestChart(t, signatory)
+	tampered := append(append([]byte{}, data...), 0)
+	tests := []struct {
+		name        string
+		keyring     *provenance.Signatory
+		archiveName string
+		data        []byte
+		prov        []byte
+		wantErr     bool
+	}{
+		{name: "signed chart", keyring: signatory, archiveName: "app-1.0.0.tgz", data: data, prov: prov},
+		{name: "no keyring", archiveName: "app-1.0.0.tgz", data: data, prov: prov, wantErr: true},
+		{name: "unknown key", keyring: newTestSignatory(t), archiveName: "app-1.0.0.tgz", data: data, prov: prov, wantErr: true},
+		{name: "tampered archive", keyring: signatory, archiveName: "app-1.0.0.tgz", data: tampered, prov: prov, wantErr: true},
+		{name: "other archive name", keyring: signatory, archiveName: "app-1.0.1.tgz", data: data, prov: prov, wantErr: true},
+		{name: "invalid provenance", keyring: signatory, archiveName: "app-1.0.0.tgz", data: data, prov: []byte("not signed"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &chartRepositoryClient{signatory: tt.keyring}
+			chart := &domain.ChartRepositoryItem{Name: "app", Version: "1.0.0"}
+			err := client.checkProvenance(context.Background(), chart, tt.archiveName, tt.data, tt.prov)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkProvenance() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -3,51 +3,49 @@ package service
 import (
 	"context"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"time"
 
+	"github.com/entwico/helm-deployer/conf"
 	"github.com/entwico/helm-deployer/conf/logging"
 	"github.com/entwico/helm-deployer/domain"
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 )
 
-const apiPathCharts = "/api/charts"
+const apiPathCharts = "api/charts"
 
 //ChartRepositoryServiceImpl is in implementation of ChartRepositoryService
 type ChartRepositoryServiceImpl struct {
-	RepositoryBaseURL string
-	HTTPClient        *http.Client
+	client *chartRepositoryClient
 }
 
-//NewChartRepositoryService returns a new instance of ChartRepositoryService
-func NewChartRepositoryService(baseURL string) domain.ChartRepositoryService {
-	return &ChartRepositoryServiceImpl{
-		RepositoryBaseURL: baseURL,
-		HTTPClient:        &http.Client{Timeout: 10 * time.Second},
+//NewChartRepositoryService returns a new instance of ChartRepositoryService for the ChartMuseum repository
+func NewChartRepositoryService(repo conf.ChartRepository, cacheDir string) (domain.ChartRepositoryService, error) {
+	client, err := newChartRepositoryClient(repo, cacheDir)
+	if err != nil {
+		return nil, err
 	}
+	return &ChartRepositoryServiceImpl{client: client}, nil
 }
 
 //FindAllCharts returns a list of helm charts
-func (c *ChartRepositoryServiceImpl) FindAllCharts(ctx context.Context) (items []domain.ChartRepositoryItem, err error) {
-	url := fmt.Sprintf("%s%s", c.RepositoryBaseURL, apiPathCharts)
+func (c *ChartRepositoryServiceImpl) FindAllCharts(ctx context.Context) ([]domain.ChartRepositoryItem, error) {
+	url, err := c.client.resolveURL(apiPathCharts)
+	if err != nil {
+		return nil, err
+	}
 	logger := logging.FromContext(ctx)
 	logger.WithField("url", url).Debug("fetching charts list")
-	r, err := http.Get(url)
+	data, err := c.client.getIndex(ctx, url)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		err = r.Body.Close()
-	}()
 
 	var res map[string][]domain.ChartRepositoryItem
-	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+	if err := json.Unmarshal(data, &res); err != nil {
 		return nil, err
 	}
 
-	items = make([]domain.ChartRepositoryItem, 0)
+	items := make([]domain.ChartRepositoryItem, 0)
 	for _, v := range res {
 		for _, i := range v {
 			items = append(items, i)
@@ -57,27 +55,65 @@ func (c *ChartRepositoryServiceImpl) FindAllCharts(ctx context.Context) (items [
 	return items, nil
 }
 
-//GetChartData returns helm chart binary data
-func (c *ChartRepositoryServiceImpl) GetChartData(ctx context.Context, chartName, chartVersion string) ([]byte, error) {
-	logger := logging.FromContext(ctx)
+//ResolveChart returns the highest chart version satisfying the version constraint
+func (c *ChartRepositoryServiceImpl) ResolveChart(ctx context.Context, chartName, versionConstraint string) (*domain.ChartRepositoryItem, error) {
 	charts, err := c.FindAllCharts(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, chart := range charts {
-		if chart.Name == chartName && chart.Version == chartVersion {
-			url := fmt.Sprintf("%s/%s", c.RepositoryBaseURL, chart.Urls[0])
-			logger.WithField("url", url).Debug("downloading chart")
-			resp, err := http.Get(url)
-			if err != nil {
-				return nil, err
-			}
-			data, err := ioutil.ReadAll(resp.Body)
-			if err := resp.Body.Close(); err != nil {
-				return nil, err
-			}
-			return data, err
+	return resolveChartVersion(charts, chartName, versionConstraint)
+}
+
+//GetChartData returns helm chart binary data, verify requires a valid provenance file of the chart
+func (c *ChartRepositoryServiceImpl) GetChartData(ctx context.Context, chart *domain.ChartRepositoryItem, verify bool) ([]byte, error) {
+	return downloadChart(ctx, c.client, chart, verify)
+}
+
+//downloadChart returns the chart archive. The provenance of the chart is verified
+//if the repository or the caller requires it
+func downloadChart(ctx context.Context, client *chartRepositoryClient, chart *domain.ChartRepositoryItem, verify bool) ([]byte, error) {
+	data, err := getChartArchive(ctx, client, chart)
+	if err != nil {
+		return nil, err
+	}
+	if verify || client.verify {
+		if err := client.verifyProvenance(ctx, chart, data); err != nil {
+			return nil, errors.Wrapf(err, "could not verify provenance of chart %s-%s", chart.Name, chart.Version)
 		}
 	}
-	return nil, errors.New("chart not found")
+	return data, nil
+}
+
+//getChartArchive returns the chart archive from the cache or downloads it from the first of its urls.
+//Downloaded and cached archives are verified against the chart digest if the chart has one
+func getChartArchive(ctx context.Context, client *chartRepositoryClient, chart *domain.ChartRepositoryItem) ([]byte, error) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"chart_name":    chart.Name,
+		"chart_version": chart.Version,
+	})
+	if chart.Digest == "" {
+		logger.Warn("chart has no digest, skipping verification")
+	}
+	if data, ok := client.cache.get(chart); ok {
+		logger.Debug("chart loaded from cache")
+		return data, nil
+	}
+	url, err := client.chartURL(chart)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("url", url).Debug("downloading chart")
+	data, err := client.getData(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if chart.Digest != "" {
+		if err := verifyChartDigest(data, chart.Digest); err != nil {
+			return nil, errors.Wrapf(err, "could not verify chart %s-%s", chart.Name, chart.Version)
+		}
+	}
+	if err := client.cache.put(chart, data); err != nil {
+		logger.WithField("error", err).Warn("could not cache chart")
+	}
+	return data, nil
 }
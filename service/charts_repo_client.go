@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+	"k8s.io/helm/pkg/provenance"
+)
+
+//chartRepositoryClient sends authenticated requests to a chart repository
+type chartRepositoryClient struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	username   string
+	password   string
+	token      string
+	cache      *chartArchiveCache
+	signatory  *provenance.Signatory
+	verify     bool
+	indexes    map[string]*cachedIndex
+	mutex      sync.Mutex
+}
+
+//cachedIndex is the last index response with its validators
+type cachedIndex struct {
+	etag         string
+	lastModified string
+	data         []byte
+}
+
+func newChartRepositoryClient(repo conf.ChartRepository, cacheDir string) (*chartRepositoryClient, error) {
+	baseURL, err := url.Parse(strings.TrimSuffix(repo.URL, "/") + "/")
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid url of chart repository '%s'", repo.Name)
+	}
+	httpClient, err := newChartRepositoryHTTPClient(repo)
+	if err != nil {
+		return nil, err
+	}
+	var signatory *provenance.Signatory
+	if repo.Keyring != "" {
+		signatory, err = provenance.NewFromKeyring(repo.Keyring, "")
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load keyring of chart repository '%s'", repo.Name)
+		}
+	}
+	return &chartRepositoryClient{
+		baseURL:    baseURL,
+		httpClient: httpClient,
+		username:   repo.Username,
+		password:   repo.Password,
+		token:      repo.Token,
+		cache:      newChartArchiveCache(cacheDir, repo.Name),
+		signatory:  signatory,
+		verify:     repo.Verify,
+		indexes:    make(map[string]*cachedIndex),
+	}, nil
+}
+
+//resolveURL resolves the reference relative to the repository url, absolute references are returned as is
+func (c *chartRepositoryClient) resolveURL(ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	return c.baseURL.ResolveReference(u).String(), nil
+}
+
+//getData downloads the resource from the url
+func (c *chartRepositoryClient) getData(ctx context.Context, url string) ([]byte, error) {
+	resp, err := c.get(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err := resp.Body.Close(); err != nil {
+		return nil, err
+	}
+	return data, err
+}
+
+//chartURL returns the absolute url of the chart archive
+func (c *chartRepositoryClient) chartURL(chart *domain.ChartRepositoryItem) (string, error) {
+	if len(chart.Urls) == 0 {
+		return "", fmt.Errorf("chart %s-%s has no urls", chart.Name, chart.Version)
+	}
+	return c.resolveURL(chart.Urls[0])
+}
+
+//getIndex downloads the index from the url. The last response is cached and revalidated with ETag or Last-Modified
+func (c *chartRepositoryClient) getIndex(ctx context.Context, url string) ([]byte, error) {
+	c.mutex.Lock()
+	cached := c.indexes[url]
+	c.mutex.Unlock()
+
+	header := make(http.Header)
+	if cached != nil {
+		if cached.etag != "" {
+			header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := c.do(ctx, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.data, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	c.mutex.Lock()
+	if etag != "" || lastModified != "" {
+		c.indexes[url] = &cachedIndex{etag: etag, lastModified: lastModified, data: data}
+	} else {
+		delete(c.indexes, url)
+	}
+	c.mutex.Unlock()
+	return data, nil
+}
+
+//get sends GET request, responses other than 200 are returned as errors
+func (c *chartRepositoryClient) get(ctx context.Context, url string) (*http.Response, error) {
+	resp, err := c.do(ctx, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+//do sends GET request with the headers. Credentials are sent only to the host of the repository
+//and only if the headers do not contain Authorization already
+func (c *chartRepositoryClient) do(ctx context.Context, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if req.URL.Host == c.baseURL.Host && req.Header.Get("Authorization") == "" {
+		switch {
+		case c.token != "":
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		case c.username != "":
+			req.SetBasicAuth(c.username, c.password)
+		}
+	}
+	return c.httpClient.Do(req.WithContext(ctx))
+}
+
+//newChartRepositoryHTTPClient returns HTTP client trusting the repository CA and presenting its client certificate
+func newChartRepositoryHTTPClient(repo conf.ChartRepository) (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: repo.InsecureSkipTLSVerify}
+	if repo.CAFile != "" {
+		caData, err := ioutil.ReadFile(repo.CAFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read CA file of chart repository '%s'", repo.Name)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in CA file of chart repository '%s'", repo.Name)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if repo.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(repo.CertFile, repo.KeyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not load client certificate of chart repository '%s'", repo.Name)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig: tlsConfig,
+	}
+	return &http.Client{Timeout: 10 * time.Second, Transport: transport}, nil
+}
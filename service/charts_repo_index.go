@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+const indexFileName = "index.yaml"
+
+//chartRepositoryIndex is the index.yaml of a chart repository
+type chartRepositoryIndex struct {
+	APIVersion string                                  `json:"apiVersion"`
+	Entries    map[string][]domain.ChartRepositoryItem `json:"entries"`
+}
+
+type indexChartRepositoryService struct {
+	client *chartRepositoryClient
+}
+
+//NewIndexChartRepositoryService returns a new instance of ChartRepositoryService for static repositories serving index.yaml,
+//such as GitHub Pages, S3 static sites or Nexus Helm repositories
+func NewIndexChartRepositoryService(repo conf.ChartRepository, cacheDir string) (domain.ChartRepositoryService, error) {
+	client, err := newChartRepositoryClient(repo, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	return &indexChartRepositoryService{client: client}, nil
+}
+
+//FindAllCharts returns all chart versions listed in the repository index
+func (s *indexChartRepositoryService) FindAllCharts(ctx context.Context) ([]domain.ChartRepositoryItem, error) {
+	url, err := s.client.resolveURL(indexFileName)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx)
+	logger.WithField("url", url).Debug("fetching repository index")
+	data, err := s.client.getIndex(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	index := new(chartRepositoryIndex)
+	if err := yaml.Unmarshal(data, index); err != nil {
+		return nil, errors.Wrap(err, "could not parse repository index")
+	}
+
+	items := make([]domain.ChartRepositoryItem, 0)
+	for _, versions := range index.Entries {
+		items = append(items, versions...)
+	}
+	return items, nil
+}
+
+//ResolveChart returns the highest chart version satisfying the version constraint
+func (s *indexChartRepositoryService) ResolveChart(ctx context.Context, chartName, versionConstraint string) (*domain.ChartRepositoryItem, error) {
+	charts, err := s.FindAllCharts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resolveChartVersion(charts, chartName, versionConstraint)
+}
+
+//GetChartData downloads the chart archive, relative urls are resolved against the repository url.
+//Verify requires a valid provenance file of the chart
+func (s *indexChartRepositoryService) GetChartData(ctx context.Context, chart *domain.ChartRepositoryItem, verify bool) ([]byte, error) {
+	return downloadChart(ctx, s.client, chart, verify)
+}
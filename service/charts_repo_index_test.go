@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/entwico/helm-deployer/conf"
+)
+
+func TestIndexChartRepositoryGetChartData(t *testing.T) {
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "mirror %s", r.URL.Path)
+	}))
+	defer mirror.Close()
+	repository := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stable/index.yaml" {
+			fmt.Fprintf(w, "repository %s", r.URL.Path)
+			return
+		}
+		fmt.Fprintf(w, `apiVersion: v1
+entries:
+  app:
+  - name: app
+    version: 1.0.0
+    urls: [app-1.0.0.tgz]
+  - name: app
+    version: 1.1.0
+    urls: [charts/app-1.1.0.tgz]
+  - name: app
+    version: 1.2.0
+    urls: [/archives/app-1.2.0.tgz]
+  - name: app
+    version: 2.0.0
+    urls: [%s/app-2.0.0.tgz, app-2.0.0.tgz]
+  - name: app
+    version: 3.0.0
+    urls: []
+`, mirror.URL)
+	}))
+	defer repository.Close()
+
+	tests := []struct {
+		name     string
+		version  string
+		wantData string
+		wantErr  bool
+	}{
+		{name: "relative to repository", version: "1.0.0", wantData: "repository /stable/app-1.0.0.tgz"},
+		{name: "relative path", version: "1.1.0", wantData: "repository /stable/charts/app-1.1.0.tgz"},
+		{name: "relative to host", version: "1.2.0", wantData: "repository /archives/app-1.2.0.tgz"},
+		{name: "absolute url first", version: "2.0.0", wantData: "mirror /app-2.0.0.tgz"},
+		{name: "no urls", version: "3.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the repository url is used with and without trailing slash
+			for _, url := range []string{repository.URL + "/stable", repository.URL + "/stable/"} {
+				s, err := NewIndexChartRepositoryService(conf.ChartRepository{Name: "stable", URL: url}, "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				chart, err := s.ResolveChart(context.Background(), "app", tt.version)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := s.GetChartData(context.Background(), chart, false)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("GetChartData() of %s error = %v, want error %v", url, err, tt.wantErr)
+				}
+				if string(data) != tt.wantData {
+					t.Errorf("GetChartData() of %s = %q, want %q", url, data, tt.wantData)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexChartRepositoryDownloadsIndexOnce(t *testing.T) {
+	indexRequests := 0
+	repository := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.yaml" {
+			indexRequests++
+			fmt.Fprint(w, "entries:\n  app:\n  - name: app\n    version: 1.0.0\n    urls: [app-1.0.0.tgz]\n")
+			return
+		}
+		fmt.Fprint(w, "chart archive")
+	}))
+	defer repository.Close()
+
+	s, err := NewIndexChartRepositoryService(conf.ChartRepository{Name: "stable", URL: repository.URL}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chart, err := s.ResolveChart(context.Background(), "app", "^1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetChartData(context.Background(), chart, false); err != nil {
+		t.Fatal(err)
+	}
+	if indexRequests != 1 {
+		t.Errorf("index downloaded %d times, want once", indexRequests)
+	}
+}
@@ -0,0 +1,351 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/distribution/registry/client/auth/challenge"
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ociScheme = "oci://"
+	// dockerHubDomain is the registry domain of Docker Hub references
+	dockerHubDomain = "docker.io"
+
+	ociManifestMediaType            = "application/vnd.oci.image.manifest.v1+json"
+	helmChartContentMediaType       = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+	helmChartLegacyContentMediaType = "application/tar+gzip"
+	helmChartProvenanceMediaType    = "application/vnd.cncf.helm.chart.provenance.v1.prov"
+)
+
+//ociDescriptor references a blob of an OCI artifact
+type ociDescriptor struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+}
+
+//ociManifest is the manifest of an OCI artifact
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociChartRepositoryService struct {
+	client *chartRepositoryClient
+	// hosts of token servers the credentials are sent to
+	tokenRealmHosts map[string]bool
+	tokens          map[string]string
+	mutex           sync.Mutex
+}
+
+//NewOCIChartRepositoryService returns a new instance of ChartRepositoryService pulling charts stored as OCI artifacts.
+//Charts are referenced as oci://registry/repository[:version], the repository url is oci://registry
+func NewOCIChartRepositoryService(repo conf.ChartRepository, cacheDir string) (domain.ChartRepositoryService, error) {
+	repo.URL = ociRegistryURL(repo.URL)
+	client, err := newChartRepositoryClient(repo, cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	tokenRealmHosts := map[string]bool{client.baseURL.Host: true}
+	for _, host := range repo.TokenRealmHosts {
+		tokenRealmHosts[host] = true
+	}
+	return &ociChartRepositoryService{client: client, tokenRealmHosts: tokenRealmHosts, tokens: make(map[string]string)}, nil
+}
+
+//FindAllCharts returns no charts, registries are not listed
+func (s *ociChartRepositoryService) FindAllCharts(ctx context.Context) ([]domain.ChartRepositoryItem, error) {
+	return make([]domain.ChartRepositoryItem, 0), nil
+}
+
+//ResolveChart returns the version of the chart reference if it has one,
+//otherwise the highest tag of the repository satisfying the version constraint
+func (s *ociChartRepositoryService) ResolveChart(ctx context.Context, chartName, versionConstraint string) (*domain.ChartRepositoryItem, error) {
+	named, tag, err := s.parseChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	if tag != "" {
+		return &domain.ChartRepositoryItem{Name: chartName, Version: ociTagVersion(tag)}, nil
+	}
+
+	data, err := s.get(ctx, named, "tags/list", "")
+	if err != nil {
+		return nil, err
+	}
+	var tags struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.Unmarshal(data, &tags); err != nil {
+		return nil, err
+	}
+	charts := make([]domain.ChartRepositoryItem, 0, len(tags.Tags))
+	for _, tag := range tags.Tags {
+		charts = append(charts, domain.ChartRepositoryItem{Name: chartName, Version: ociTagVersion(tag)})
+	}
+	return resolveChartVersion(charts, chartName, versionConstraint)
+}
+
+//GetChartData pulls the chart layer of the artifact, verify requires a valid provenance layer
+func (s *ociChartRepositoryService) GetChartData(ctx context.Context, resolved *domain.ChartRepositoryItem, verify bool) ([]byte, error) {
+	chartName, chartVersion := resolved.Name, resolved.Version
+	named, _, err := s.parseChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"chart_name":    chartName,
+		"chart_version": chartVersion,
+	})
+
+	data, err := s.get(ctx, named, "manifests/"+ociVersionTag(chartVersion), ociManifestMediaType)
+	if err != nil {
+		return nil, err
+	}
+	manifest := new(ociManifest)
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrap(err, "could not parse artifact manifest")
+	}
+	var content, prov *ociDescriptor
+	for i, layer := range manifest.Layers {
+		switch layer.MediaType {
+		case helmChartContentMediaType, helmChartLegacyContentMediaType:
+			content = &manifest.Layers[i]
+		case helmChartProvenanceMediaType:
+			prov = &manifest.Layers[i]
+		}
+	}
+	if content == nil {
+		return nil, fmt.Errorf("artifact %s:%s has no chart layer", named, chartVersion)
+	}
+
+	chart := &domain.ChartRepositoryItem{Name: chartName, Version: chartVersion, Digest: content.Digest.String()}
+	data, ok := s.client.cache.get(chart)
+	if ok {
+		logger.Debug("chart loaded from cache")
+	} else {
+		logger.WithField("digest", chart.Digest).Debug("pulling chart")
+		if data, err = s.getBlob(ctx, named, content.Digest); err != nil {
+			return nil, err
+		}
+		if err := s.client.cache.put(chart, data); err != nil {
+			logger.WithField("error", err).Warn("could not cache chart")
+		}
+	}
+
+	if verify || s.client.verify {
+		if prov == nil {
+			return nil, fmt.Errorf("could not verify provenance of chart %s-%s: chart is not signed", chartName, chartVersion)
+		}
+		provData, err := s.getBlob(ctx, named, prov.Digest)
+		if err != nil {
+			return nil, err
+		}
+		archiveName := fmt.Sprintf("%s-%s.tgz", path.Base(reference.Path(named)), chartVersion)
+		if err := s.client.checkProvenance(ctx, chart, archiveName, data, provData); err != nil {
+			return nil, errors.Wrapf(err, "could not verify provenance of chart %s-%s", chartName, chartVersion)
+		}
+	}
+	return data, nil
+}
+
+//getBlob downloads the blob and verifies it against its digest
+func (s *ociChartRepositoryService) getBlob(ctx context.Context, named reference.Named, d digest.Digest) ([]byte, error) {
+	if err := d.Validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid blob digest %s", d)
+	}
+	data, err := s.get(ctx, named, "blobs/"+d.String(), "")
+	if err != nil {
+		return nil, err
+	}
+	verifier := d.Verifier()
+	if _, err := verifier.Write(data); err != nil {
+		return nil, err
+	}
+	if !verifier.Verified() {
+		return nil, fmt.Errorf("blob %s of %s does not match its digest", d, named)
+	}
+	return data, nil
+}
+
+//parseChart returns the repository and the tag of the chart reference, charts of other registries are refused
+func (s *ociChartRepositoryService) parseChart(chartName string) (reference.Named, string, error) {
+	named, tag, err := parseOCIChart(chartName)
+	if err != nil {
+		return nil, "", err
+	}
+	scheme := s.client.baseURL.Scheme
+	if normalizeRegistryHost(scheme, reference.Domain(named)) != normalizeRegistryHost(scheme, s.client.baseURL.Host) {
+		return nil, "", fmt.Errorf("chart '%s' is not stored in registry '%s'", chartName, s.client.baseURL.Host)
+	}
+	return named, tag, nil
+}
+
+//get sends request to the registry API of the repository.
+//If the registry asks for a bearer token, the token is requested from its auth server using the repository credentials
+func (s *ociChartRepositoryService) get(ctx context.Context, named reference.Named, apiPath, accept string) ([]byte, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/%s", s.client.baseURL.Scheme, s.client.baseURL.Host, reference.Path(named), apiPath)
+	scope := fmt.Sprintf("repository:%s:pull", reference.Path(named))
+	header := make(http.Header)
+	if accept != "" {
+		header.Set("Accept", accept)
+	}
+	s.mutex.Lock()
+	token := s.tokens[scope]
+	s.mutex.Unlock()
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := s.client.do(ctx, url, header)
+	if err == nil && resp.StatusCode == http.StatusUnauthorized {
+		for _, c := range challenge.ResponseChallenges(resp) {
+			if !strings.EqualFold(c.Scheme, "bearer") {
+				continue
+			}
+			_ = resp.Body.Close()
+			if token, err = s.fetchToken(ctx, c.Parameters, scope); err != nil {
+				return nil, err
+			}
+			header.Set("Authorization", "Bearer "+token)
+			resp, err = s.client.do(ctx, url, header)
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+//fetchToken requests a pull token for the scope from the auth server of the registry.
+//Credentials are sent only to the registry host and the configured token realm hosts
+func (s *ociChartRepositoryService) fetchToken(ctx context.Context, params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" || (realm.Scheme != "https" && realm.Scheme != "http") {
+		return "", fmt.Errorf("registry returned invalid token realm '%s'", params["realm"])
+	}
+	query := realm.Query()
+	if service := params["service"]; service != "" {
+		query.Set("service", service)
+	}
+	query.Set("scope", scope)
+	realm.RawQuery = query.Encode()
+
+	header := make(http.Header)
+	if s.client.username != "" {
+		if !s.tokenRealmHosts[realm.Host] {
+			return "", fmt.Errorf("registry token realm host '%s' is not allowed to receive the repository credentials", realm.Host)
+		}
+		if realm.Scheme != "https" && s.client.baseURL.Scheme == "https" {
+			return "", fmt.Errorf("registry token realm '%s' does not use https", realm.Host)
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(s.client.username + ":" + s.client.password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+	resp, err := s.client.do(ctx, realm.String(), header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not get registry token: GET %s returned %s", realm.Host, resp.Status)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", errors.Wrap(err, "could not parse registry token")
+	}
+	token := body.Token
+	if token == "" {
+		token = body.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("registry returned empty token")
+	}
+
+	s.mutex.Lock()
+	s.tokens[scope] = token
+	s.mutex.Unlock()
+	return token, nil
+}
+
+//isOCIChart returns true if the chart is referenced as oci://registry/repository
+func isOCIChart(chartName string) bool {
+	return strings.HasPrefix(chartName, ociScheme)
+}
+
+//parseOCIChart returns the repository and the tag of chart reference oci://registry/repository[:tag]
+func parseOCIChart(chartName string) (reference.Named, string, error) {
+	if !isOCIChart(chartName) {
+		return nil, "", fmt.Errorf("chart '%s' must be referenced as %sregistry/repository", chartName, ociScheme)
+	}
+	named, err := reference.ParseNamed(strings.TrimPrefix(chartName, ociScheme))
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid chart reference '%s'", chartName)
+	}
+	var tag string
+	if tagged, ok := named.(reference.Tagged); ok {
+		tag = tagged.Tag()
+	}
+	return reference.TrimNamed(named), tag, nil
+}
+
+//ociRegistryURL returns the registry API url of the repository url, oci://registry is served over https
+func ociRegistryURL(repoURL string) string {
+	if strings.HasPrefix(repoURL, ociScheme) {
+		return "https://" + strings.TrimPrefix(repoURL, ociScheme)
+	}
+	return repoURL
+}
+
+//normalizeRegistryHost returns the host the way it is compared with chart references:
+//lower case, without the default port of the scheme, Docker Hub registry hosts are replaced with docker.io
+func normalizeRegistryHost(scheme, host string) string {
+	host = strings.ToLower(host)
+	if h, port, err := net.SplitHostPort(host); err == nil && (scheme == "https" && port == "443" || scheme == "http" && port == "80") {
+		host = h
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return dockerHubDomain
+	}
+	return host
+}
+
+//ociVersionTag returns the tag of the chart version, OCI tags can not contain '+'
+func ociVersionTag(version string) string {
+	return strings.Replace(version, "+", "_", -1)
+}
+
+//ociTagVersion returns the chart version of the tag
+func ociTagVersion(tag string) string {
+	return strings.Replace(tag, "_", "+", -1)
+}
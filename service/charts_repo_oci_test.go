@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/opencontainers/go-digest"
+)
+
+//testRegistry serves the artifact of chart team/app:1.0.0, pulls require a token issued for the configured credentials
+type testRegistry struct {
+	server *httptest.Server
+	// realm sent in the bearer challenge, the token endpoint of the registry if empty
+	realm string
+	// blobs by digest
+	blobs    map[digest.Digest][]byte
+	manifest ociManifest
+}
+
+func newTestRegistry(t *testing.T, chartData, prov []byte) *testRegistry {
+	r := &testRegistry{blobs: make(map[digest.Digest][]byte)}
+	r.manifest = ociManifest{SchemaVersion: 2, Layers: []ociDescriptor{r.addBlob(helmChartContentMediaType, chartData)}}
+	if prov != nil {
+		r.manifest.Layers = append(r.manifest.Layers, r.addBlob(helmChartProvenanceMediaType, prov))
+	}
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+func (r *testRegistry) addBlob(mediaType string, data []byte) ociDescriptor {
+	d := digest.FromBytes(data)
+	r.blobs[d] = data
+	return ociDescriptor{MediaType: mediaType, Digest: d, Size: int64(len(data))}
+}
+
+func (r *testRegistry) host() string {
+	u, _ := url.Parse(r.server.URL)
+	return u.Host
+}
+
+func (r *testRegistry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if username, password, ok := req.BasicAuth(); !ok || username != "deployer" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": "pull-token"})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer pull-token" {
+		realm := r.realm
+		if realm == "" {
+			realm = r.server.URL + "/token"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry"`, realm))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case req.URL.Path == "/v2/team/app/manifests/1.0.0":
+		_ = json.NewEncoder(w).Encode(r.manifest)
+	case len(req.URL.Path) > len("/v2/team/app/blobs/"):
+		data, ok := r.blobs[digest.Digest(req.URL.Path[len("/v2/team/app/blobs/"):])]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestOCIChartRepositoryService(t *testing.T, r *testRegistry, repo conf.ChartRepository) *ociChartRepositoryService {
+	repo.Name = "harbor"
+	repo.URL = ociScheme + r.host()
+	repo.Username, repo.Password = "deployer", "secret"
+	service, err := NewOCIChartRepositoryService(repo, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := service.(*ociChartRepositoryService)
+	s.client.httpClient = r.server.Client()
+	return s
+}
+
+func TestOCIChartRepositoryGetChartData(t *testing.T) {
+	signatory := newTestSignatory(t)
+	chartData, prov := newSignedTestChart(t, signatory)
+
+	r := newTestRegistry(t, chartData, prov)
+	s := newTestOCIChartRepositoryService(t, r, conf.ChartRepository{})
+	s.client.signatory = signatory
+	data, err := s.GetChartData(context.Background(), &domain.ChartRepositoryItem{Name: ociScheme + r.host() + "/team/app", Version: "1.0.0"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(chartData) {
+		t.Error("GetChartData() returned other data than the chart layer")
+	}
+}
+
+func TestOCIChartRepositoryGetChartDataErrors(t *testing.T) {
+	signatory := newTestSignatory(t)
+	chartData, prov := newSignedTestChart(t, signatory)
+	tests := []struct {
+		name string
+		// setup changes the registry before the chart is pulled
+		setup     func(r *testRegistry)
+		chartName func(r *testRegistry) string
+		verify    bool
+	}{
+		{
+			name: "blob not matching its digest",
+			setup: func(r *testRegistry) {
+				r.blobs[r.manifest.Layers[0].Digest] = []byte("tampered archive")
+			},
+		},
+		{
+			name: "unsigned chart",
+			setup: func(r *testRegistry) {
+				r.manifest.Layers = r.manifest.Layers[:1]
+			},
+			verify: true,
+		},
+		{
+			name: "provenance of other chart",
+			setup: func(r *testRegistry) {
+				_, otherProv := newSignedTestChart(t, newTestSignatory(t))
+				r.manifest.Layers[1] = r.addBlob(helmChartProvenanceMediaType, otherProv)
+			},
+			verify: true,
+		},
+		{
+			name: "chart of other registry",
+			chartName: func(r *testRegistry) string {
+				return ociScheme + "registry.example.com/team/app"
+			},
+		},
+		{
+			name: "token realm of other host",
+			setup: func(r *testRegistry) {
+				r.realm = "https://auth.example.com/token"
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newTestRegistry(t, chartData, prov)
+			if tt.setup != nil {
+				tt.setup(r)
+			}
+			chartName := ociScheme + r.host() + "/team/app"
+			if tt.chartName != nil {
+				chartName = tt.chartName(r)
+			}
+			s := newTestOCIChartRepositoryService(t, r, conf.ChartRepository{})
+			s.client.signatory = signatory
+			if _, err := s.GetChartData(context.Background(), &domain.ChartRepositoryItem{Name: chartName, Version: "1.0.0"}, tt.verify); err == nil {
+				t.Error("GetChartData() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestChartRepositoryRegistryOCIRepository(t *testing.T) {
+	harbor := &fakeChartRepositoryService{}
+	r := &chartRepositoryRegistryImpl{repositories: []namedChartRepository{
+		{name: "stable", service: &fakeChartRepositoryService{}},
+		{name: "harbor", service: harbor, ociHost: "harbor.example.com"},
+	}}
+	service, err := r.Repository("", ociScheme+"harbor.example.com/team/app:1.0.0")
+	if err != nil || service != harbor {
+		t.Errorf("Repository() = %v, %v, want the configured registry", service, err)
+	}
+	if _, err := r.Repository("", ociScheme+"registry.example.com/team/app:1.0.0"); err == nil {
+		t.Error("Repository() of an unconfigured registry succeeded, want error")
+	}
+}
+
+func TestOCIChartRepositoryParseChart(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		chart   string
+		wantErr bool
+	}{
+		{name: "same host", url: "oci://registry.example.com", chart: "registry.example.com/team/app"},
+		{name: "default port in reference", url: "oci://registry.example.com", chart: "registry.example.com:443/team/app"},
+		{name: "default port in url", url: "https://registry.example.com:443", chart: "registry.example.com/team/app"},
+		{name: "default http port", url: "http://localhost:80", chart: "localhost/team/app"},
+		{name: "custom port", url: "https://registry.example.com:5000", chart: "registry.example.com:5000/team/app"},
+		{name: "docker hub", url: "oci://registry-1.docker.io", chart: "docker.io/team/app"},
+		{name: "docker hub index", url: "https://index.docker.io", chart: "registry-1.docker.io/team/app"},
+		{name: "other port", url: "oci://registry.example.com", chart: "registry.example.com:5000/team/app", wantErr: true},
+		{name: "http port on https", url: "oci://registry.example.com", chart: "registry.example.com:80/team/app", wantErr: true},
+		{name: "other host", url: "oci://registry.example.com", chart: "docker.io/team/app", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewOCIChartRepositoryService(conf.ChartRepository{Name: "registry", URL: tt.url}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _, err = s.(*ociChartRepositoryService).parseChart(ociScheme + tt.chart + ":1.0.0")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseChart() error = %v, want error %v", err, tt.wantErr)
+			}
+
+			r, err := NewChartRepositoryRegistry([]conf.ChartRepository{{Name: "registry", URL: tt.url, Type: conf.ChartRepositoryTypeOCI}}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.Repository("", ociScheme+tt.chart); (err != nil) != tt.wantErr {
+				t.Errorf("Repository() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/docker/distribution/reference"
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+type namedChartRepository struct {
+	name    string
+	service domain.ChartRepositoryService
+	// normalized registry host and scheme of OCI repositories, see normalizeRegistryHost
+	ociHost   string
+	ociScheme string
+}
+
+type chartRepositoryRegistryImpl struct {
+	repositories []namedChartRepository
+}
+
+//NewChartRepositoryRegistry returns a new instance of ChartRepositoryRegistry.
+//The first repository is used by DeployConfigs without repository, chart archives are cached in cacheDir
+func NewChartRepositoryRegistry(repositories []conf.ChartRepository, cacheDir string) (domain.ChartRepositoryRegistry, error) {
+	registry := new(chartRepositoryRegistryImpl)
+	for _, repo := range repositories {
+		item := namedChartRepository{name: repo.Name}
+		var err error
+		switch repo.Type {
+		case conf.ChartRepositoryTypeIndex:
+			item.service, err = NewIndexChartRepositoryService(repo, cacheDir)
+		case conf.ChartRepositoryTypeOCI:
+			item.service, err = NewOCIChartRepositoryService(repo, cacheDir)
+			if err == nil {
+				u, _ := url.Parse(ociRegistryURL(repo.URL))
+				item.ociHost = normalizeRegistryHost(u.Scheme, u.Host)
+				item.ociScheme = u.Scheme
+			}
+		default:
+			item.service, err = NewChartRepositoryService(repo, cacheDir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		registry.repositories = append(registry.repositories, item)
+	}
+	return registry, nil
+}
+
+//FindAllCharts returns charts of all repositories labelled with the repository name.
+//Repositories which can not be listed are skipped, an error is returned only if none of them could be listed
+func (r *chartRepositoryRegistryImpl) FindAllCharts(ctx context.Context) ([]domain.ChartRepositoryItem, error) {
+	logger := logging.FromContext(ctx)
+	items := make([]domain.ChartRepositoryItem, 0)
+	var lastErr error
+	failed := 0
+	for _, repo := range r.repositories {
+		charts, err := repo.service.FindAllCharts(ctx)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"repository": repo.name,
+				"error":      err,
+			}).Warn("could not list charts of repository, skipping it")
+			lastErr = errors.Wrapf(err, "could not list charts of repository '%s'", repo.name)
+			failed++
+			continue
+		}
+		for _, chart := range charts {
+			chart.Repository = repo.name
+			items = append(items, chart)
+		}
+	}
+	if failed > 0 && failed == len(r.repositories) {
+		return nil, lastErr
+	}
+	return items, nil
+}
+
+//Repository returns the repository serving the chart. Repository is looked up by its name,
+//empty name returns the first repository or, for oci:// charts, the OCI repository of the chart registry
+func (r *chartRepositoryRegistryImpl) Repository(name, chartName string) (domain.ChartRepositoryService, error) {
+	if name != "" {
+		for _, repo := range r.repositories {
+			if repo.name == name {
+				return repo.service, nil
+			}
+		}
+		return nil, fmt.Errorf("chart repository '%s' not configured", name)
+	}
+	if isOCIChart(chartName) {
+		return r.ociRepository(chartName)
+	}
+	for _, repo := range r.repositories {
+		if repo.ociHost == "" {
+			return repo.service, nil
+		}
+	}
+	return nil, errors.New("no chart repositories configured")
+}
+
+//ociRepository returns the configured OCI repository of the chart registry,
+//charts of registries which are not configured are refused
+func (r *chartRepositoryRegistryImpl) ociRepository(chartName string) (domain.ChartRepositoryService, error) {
+	named, _, err := parseOCIChart(chartName)
+	if err != nil {
+		return nil, err
+	}
+	host := reference.Domain(named)
+	for _, repo := range r.repositories {
+		if repo.ociHost != "" && repo.ociHost == normalizeRegistryHost(repo.ociScheme, host) {
+			return repo.service, nil
+		}
+	}
+	return nil, fmt.Errorf("chart registry '%s' not configured", host)
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+//fakeChartRepositoryService lists fixed charts or fails with err
+type fakeChartRepositoryService struct {
+	domain.ChartRepositoryService
+	charts []domain.ChartRepositoryItem
+	err    error
+}
+
+func (s *fakeChartRepositoryService) FindAllCharts(ctx context.Context) ([]domain.ChartRepositoryItem, error) {
+	return s.charts, s.err
+}
+
+func TestChartRepositoryRegistryFindAllCharts(t *testing.T) {
+	available := namedChartRepository{name: "stable", service: &fakeChartRepositoryService{charts: []domain.ChartRepositoryItem{{Name: "app"}}}}
+	unreachable := namedChartRepository{name: "internal", service: &fakeChartRepositoryService{err: errors.New("connection refused")}}
+	tests := []struct {
+		name         string
+		repositories []namedChartRepository
+		want         []string
+		wantErr      bool
+	}{
+		{name: "unreachable repository is skipped", repositories: []namedChartRepository{unreachable, available}, want: []string{"stable/app"}},
+		{name: "all repositories unreachable", repositories: []namedChartRepository{unreachable}, wantErr: true},
+		{name: "no repositories", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &chartRepositoryRegistryImpl{repositories: tt.repositories}
+			items, err := r.FindAllCharts(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("FindAllCharts() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(items) != len(tt.want) {
+				t.Fatalf("FindAllCharts() = %v, want %v", items, tt.want)
+			}
+			for i, item := range items {
+				if got := item.Repository + "/" + item.Name; got != tt.want[i] {
+					t.Errorf("FindAllCharts()[%d] = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
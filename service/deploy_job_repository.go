@@ -0,0 +1,121 @@
+package service
+
+import (
+	"encoding/json"
+
+	"github.com/coreos/bbolt"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+var deployJobsBucket = []byte("deployJobs")
+
+//BoltDeployJobRepository is an implementation of the DeployJobRepository interface which uses BoltDB
+type BoltDeployJobRepository struct {
+	db *bolt.DB
+}
+
+//NewDeployJobRepository returns a new instance of the DeployJobRepository
+func NewDeployJobRepository(db *bolt.DB) (domain.DeployJobRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deployJobsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltDeployJobRepository{db}, nil
+}
+
+//FindAll returns all DeployJobs
+func (r *BoltDeployJobRepository) FindAll() ([]domain.DeployJob, error) {
+	items := make([]domain.DeployJob, 0)
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deployJobsBucket)
+		return b.ForEach(func(k, v []byte) error {
+			item, err := decodeDeployJob(v)
+			if err != nil {
+				return err
+			}
+			items = append(items, *item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//FindOne returns a DeployJob by its id
+func (r *BoltDeployJobRepository) FindOne(id string) (*domain.DeployJob, error) {
+	var item *domain.DeployJob
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		b := tx.Bucket(deployJobsBucket)
+		itemData := b.Get([]byte(id))
+		if len(itemData) == 0 {
+			return nil
+		}
+		item, err = decodeDeployJob(itemData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//Save persists DeployJob to the database
+func (r *BoltDeployJobRepository) Save(item *domain.DeployJob) (*domain.DeployJob, error) {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deployJobsBucket)
+		if item.ID == "" {
+			item.ID = bson.NewObjectId()
+		}
+		enc, err := encodeDeployJob(item)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(item.ID.Hex()), enc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+//Delete removes a DeployJob
+func (r *BoltDeployJobRepository) Delete(id string) error {
+	item, err := r.FindOne(id)
+	if err != nil {
+		return err
+	}
+	if item == nil {
+		return errors.New("not found")
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deployJobsBucket)
+		return b.Delete([]byte(id))
+	})
+}
+
+func encodeDeployJob(p *domain.DeployJob) ([]byte, error) {
+	enc, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func decodeDeployJob(data []byte) (*domain.DeployJob, error) {
+	var item *domain.DeployJob
+	err := json.Unmarshal(data, &item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
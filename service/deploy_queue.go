@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const deployQueuePollInterval = time.Second
+
+type deployQueueImpl struct {
+	repository        domain.DeployJobRepository
+	deploymentService domain.DeploymentService
+	workers           int
+	maxAttempts       int
+	initialBackoff    time.Duration
+	maxBackoff        time.Duration
+	wakeup            chan struct{}
+	// releases with a running job, keyed by releaseKey
+	running map[string]bool
+	mutex   sync.Mutex
+}
+
+//NewDeployQueue returns a new instance of DeployQueue.
+//Jobs are run by a pool of workers, failed jobs are retried with exponential backoff until maxAttempts is reached
+func NewDeployQueue(repository domain.DeployJobRepository, deploymentService domain.DeploymentService, workers, maxAttempts int, initialBackoff, maxBackoff time.Duration) domain.DeployQueue {
+	return &deployQueueImpl{
+		repository:        repository,
+		deploymentService: deploymentService,
+		workers:           workers,
+		maxAttempts:       maxAttempts,
+		initialBackoff:    initialBackoff,
+		maxBackoff:        maxBackoff,
+		wakeup:            make(chan struct{}, 1),
+		running:           make(map[string]bool),
+	}
+}
+
+//Start resumes unfinished jobs and runs due jobs until the context is done
+func (q *deployQueueImpl) Start(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	if err := q.resume(); err != nil {
+		logger.WithField("error", err).Error("could not resume deploy jobs")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(q.workers)
+	for i := 0; i < q.workers; i++ {
+		go func(worker int) {
+			defer wg.Done()
+			q.work(logging.NewContextWithLogger(ctx, logger.WithField("worker", worker)))
+		}(i)
+	}
+	wg.Wait()
+}
+
+func (q *deployQueueImpl) work(ctx context.Context) {
+	ticker := time.NewTicker(deployQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		q.runDueJobs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wakeup:
+		case <-ticker.C:
+		}
+	}
+}
+
+//Enqueue persists a new deploy job.
+//If the release in the same namespace already has a pending job, the newer DeployEvent replaces the pending one
+func (q *deployQueueImpl) Enqueue(event domain.DeployEvent) (*domain.DeployJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	now := time.Now()
+
+	pending, err := q.FindAll(domain.DeployJobStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pending {
+		job := &pending[i]
+		if releaseKey(job.DeployConfig) != releaseKey(event.DeployConfig) {
+			continue
+		}
+		job.DeployEvent = event
+		job.MergedEvents++
+		job.Attempts = 0
+		job.NextAttemptAt = now
+		job.UpdatedAt = now
+		if _, err := q.repository.Save(job); err != nil {
+			return nil, err
+		}
+		q.notify()
+		return job, nil
+	}
+
+	job := &domain.DeployJob{
+		DeployEvent:   event,
+		Status:        domain.DeployJobStatusPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if _, err := q.repository.Save(job); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+//FindAll returns deploy jobs with the given status, all jobs if status is empty
+func (q *deployQueueImpl) FindAll(status string) ([]domain.DeployJob, error) {
+	items, err := q.repository.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]domain.DeployJob, 0)
+	for _, item := range items {
+		if status == "" || item.Status == status {
+			result = append(result, item)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].CreatedAt.Before(result[j].CreatedAt)
+	})
+	return result, nil
+}
+
+//FindOne returns deploy job by its id
+func (q *deployQueueImpl) FindOne(id string) (*domain.DeployJob, error) {
+	return q.repository.FindOne(id)
+}
+
+//Retry moves a dead job back to the queue
+func (q *deployQueueImpl) Retry(id string) (*domain.DeployJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, err := q.repository.FindOne(id)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, errors.New("item not found")
+	}
+	if job.Status != domain.DeployJobStatusDead {
+		return nil, errors.New("only dead jobs can be retried")
+	}
+	job.Status = domain.DeployJobStatusPending
+	job.Attempts = 0
+	job.NextAttemptAt = time.Now()
+	job.UpdatedAt = time.Now()
+	if _, err := q.repository.Save(job); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+//Delete removes a job which is not running
+func (q *deployQueueImpl) Delete(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	job, err := q.repository.FindOne(id)
+	if err != nil {
+		return err
+	}
+	if job != nil && job.Status == domain.DeployJobStatusRunning {
+		return errors.New("running jobs can not be deleted")
+	}
+	return q.repository.Delete(id)
+}
+
+func (q *deployQueueImpl) notify() {
+	select {
+	case q.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+//resume returns jobs interrupted by a restart to the queue
+func (q *deployQueueImpl) resume() error {
+	jobs, err := q.FindAll(domain.DeployJobStatusRunning)
+	if err != nil {
+		return err
+	}
+	for i := range jobs {
+		job := &jobs[i]
+		job.Status = domain.DeployJobStatusPending
+		job.NextAttemptAt = time.Now()
+		if _, err := q.repository.Save(job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (q *deployQueueImpl) runDueJobs(ctx context.Context) {
+	logger := logging.FromContext(ctx)
+	for {
+		job, err := q.claimDueJob()
+		if err != nil {
+			logger.WithField("error", err).Error("could not get next deploy job")
+			return
+		}
+		if job == nil {
+			return
+		}
+		q.run(ctx, job)
+	}
+}
+
+//claimDueJob marks the oldest due pending job of a release without running jobs as running and returns it
+func (q *deployQueueImpl) claimDueJob() (*domain.DeployJob, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	jobs, err := q.FindAll(domain.DeployJobStatusPending)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range jobs {
+		job := &jobs[i]
+		key := releaseKey(job.DeployConfig)
+		if job.NextAttemptAt.After(now) || q.running[key] {
+			continue
+		}
+		q.running[key] = true
+		job.Status = domain.DeployJobStatusRunning
+		job.Attempts++
+		job.UpdatedAt = now
+		if _, err := q.repository.Save(job); err != nil {
+			delete(q.running, key)
+			return nil, err
+		}
+		return job, nil
+	}
+	return nil, nil
+}
+
+func (q *deployQueueImpl) run(ctx context.Context, job *domain.DeployJob) {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"job_id":    job.ID.Hex(),
+		"release":   job.DeployConfig.ReleaseName,
+		"namespace": releaseNamespace(job.DeployConfig),
+		"attempt":   job.Attempts,
+	})
+	logger.Debug("running deploy job")
+	defer q.unlockRelease(releaseKey(job.DeployConfig))
+
+	_, err := q.deploymentService.Deploy(ctx, job.DeployEvent)
+	if err == nil {
+		logger.Debug("deploy job finished")
+		if err := q.repository.Delete(job.ID.Hex()); err != nil {
+			logger.WithField("error", err).Error("could not remove deploy job")
+		}
+		return
+	}
+
+	q.saveFailedJob(job, err, logger)
+}
+
+//saveFailedJob schedules the next attempt or moves the job to the dead state.
+//The job is dropped if a newer event for the release has been queued meanwhile
+func (q *deployQueueImpl) saveFailedJob(job *domain.DeployJob, deployErr error, logger *log.Entry) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	pending, err := q.FindAll(domain.DeployJobStatusPending)
+	if err != nil {
+		logger.WithField("error", err).Error("could not get pending deploy jobs")
+	}
+	for _, item := range pending {
+		if releaseKey(item.DeployConfig) == releaseKey(job.DeployConfig) {
+			logger.WithFields(log.Fields{
+				"error":         deployErr,
+				"superseded_by": item.ID.Hex(),
+			}).Warn("deploy job failed, superseded by newer job")
+			if err := q.repository.Delete(job.ID.Hex()); err != nil {
+				logger.WithField("error", err).Error("could not remove deploy job")
+			}
+			return
+		}
+	}
+
+	job.LastError = deployErr.Error()
+	job.UpdatedAt = time.Now()
+	if job.Attempts >= q.maxAttempts {
+		job.Status = domain.DeployJobStatusDead
+		logger.WithField("error", deployErr).Error("deploy job failed, giving up")
+	} else {
+		job.Status = domain.DeployJobStatusPending
+		job.NextAttemptAt = time.Now().Add(q.backoff(job.Attempts))
+		logger.WithFields(log.Fields{
+			"error":           deployErr,
+			"next_attempt_at": job.NextAttemptAt,
+		}).Warn("deploy job failed, will retry")
+	}
+	if _, err := q.repository.Save(job); err != nil {
+		logger.WithField("error", err).Error("could not save deploy job")
+	}
+}
+
+//unlockRelease frees the lane of the release and wakes up workers waiting for it
+func (q *deployQueueImpl) unlockRelease(key string) {
+	q.mutex.Lock()
+	delete(q.running, key)
+	q.mutex.Unlock()
+	q.notify()
+}
+
+//releaseKey identifies the lane of a release, releases are unique per namespace
+func releaseKey(cfg domain.DeployConfig) string {
+	return releaseNamespace(cfg) + "/" + cfg.ReleaseName
+}
+
+//backoff returns the delay before the next attempt, doubling with every attempt
+func (q *deployQueueImpl) backoff(attempts int) time.Duration {
+	d := q.initialBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= q.maxBackoff {
+			return q.maxBackoff
+		}
+	}
+	if d > q.maxBackoff {
+		return q.maxBackoff
+	}
+	return d
+}
@@ -0,0 +1,326 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/globalsign/mgo/bson"
+)
+
+//fakeDeployJobRepository keeps DeployJobs in memory
+type fakeDeployJobRepository struct {
+	items map[string]domain.DeployJob
+}
+
+func newFakeDeployJobRepository() *fakeDeployJobRepository {
+	return &fakeDeployJobRepository{items: make(map[string]domain.DeployJob)}
+}
+
+func (r *fakeDeployJobRepository) FindAll() ([]domain.DeployJob, error) {
+	items := make([]domain.DeployJob, 0, len(r.items))
+	for _, item := range r.items {
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (r *fakeDeployJobRepository) FindOne(id string) (*domain.DeployJob, error) {
+	item, ok := r.items[id]
+	if !ok {
+		return nil, nil
+	}
+	return &item, nil
+}
+
+func (r *fakeDeployJobRepository) Save(item *domain.DeployJob) (*domain.DeployJob, error) {
+	if item.ID == "" {
+		item.ID = bson.NewObjectId()
+	}
+	r.items[item.ID.Hex()] = *item
+	return item, nil
+}
+
+func (r *fakeDeployJobRepository) Delete(id string) error {
+	delete(r.items, id)
+	return nil
+}
+
+//fakeDeploymentService fails deploys while err is set
+type fakeDeploymentService struct {
+	domain.DeploymentService
+	err    error
+	events []domain.DeployEvent
+}
+
+func (s *fakeDeploymentService) Deploy(ctx context.Context, event domain.DeployEvent) (*domain.Deployment, error) {
+	s.events = append(s.events, event)
+	return &domain.Deployment{ReleaseName: event.DeployConfig.ReleaseName}, s.err
+}
+
+func newTestDeployQueue(deployErr error) (*deployQueueImpl, *fakeDeployJobRepository, *fakeDeploymentService) {
+	repository := newFakeDeployJobRepository()
+	deploymentService := &fakeDeploymentService{err: deployErr}
+	q := NewDeployQueue(repository, deploymentService, 1, 2, time.Minute, time.Hour).(*deployQueueImpl)
+	return q, repository, deploymentService
+}
+
+func deployEvent(releaseName, imageTag string) domain.DeployEvent {
+	return domain.DeployEvent{DeployConfig: domain.DeployConfig{ReleaseName: releaseName}, ImageTag: imageTag}
+}
+
+func TestDeployQueueEnqueueMergesPendingJobs(t *testing.T) {
+	q, repository, _ := newTestDeployQueue(nil)
+	first, err := q.Enqueue(deployEvent("app", "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Enqueue(deployEvent("api", "1.0")); err != nil {
+		t.Fatal(err)
+	}
+	merged, err := q.Enqueue(deployEvent("app", "1.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ID != first.ID {
+		t.Errorf("Enqueue() created job %s, want merge into %s", merged.ID.Hex(), first.ID.Hex())
+	}
+	if len(repository.items) != 2 {
+		t.Errorf("queue has %d jobs, want 2", len(repository.items))
+	}
+	job := repository.items[first.ID.Hex()]
+	if job.Status != domain.DeployJobStatusPending || job.MergedEvents != 1 || job.ImageTag != "1.1" {
+		t.Errorf("merged job = %+v, want pending job of the newer event", job)
+	}
+}
+
+func TestDeployQueueEnqueueKeysByNamespace(t *testing.T) {
+	tests := []struct {
+		name     string
+		first    domain.DeployConfig
+		second   domain.DeployConfig
+		wantJobs int
+	}{
+		{
+			name:     "same release in same namespace is merged",
+			first:    domain.DeployConfig{ReleaseName: "app", Namespace: "dev"},
+			second:   domain.DeployConfig{ReleaseName: "app", Namespace: "dev"},
+			wantJobs: 1,
+		},
+		{
+			name:     "empty namespace is the default namespace",
+			first:    domain.DeployConfig{ReleaseName: "app"},
+			second:   domain.DeployConfig{ReleaseName: "app", Namespace: "default"},
+			wantJobs: 1,
+		},
+		{
+			name:     "same release in other namespace is kept",
+			first:    domain.DeployConfig{ReleaseName: "app", Namespace: "dev"},
+			second:   domain.DeployConfig{ReleaseName: "app", Namespace: "prod"},
+			wantJobs: 2,
+		},
+		{
+			name:     "same release behind other repository in other namespace is kept",
+			first:    domain.DeployConfig{ReleaseName: "app", Repository: "stable", Namespace: "dev"},
+			second:   domain.DeployConfig{ReleaseName: "app", Repository: "incubator", Namespace: "prod"},
+			wantJobs: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, repository, _ := newTestDeployQueue(nil)
+			if _, err := q.Enqueue(domain.DeployEvent{DeployConfig: tt.first, ImageTag: "1.0"}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := q.Enqueue(domain.DeployEvent{DeployConfig: tt.second, ImageTag: "1.1"}); err != nil {
+				t.Fatal(err)
+			}
+			if len(repository.items) != tt.wantJobs {
+				t.Errorf("queue has %d jobs, want %d", len(repository.items), tt.wantJobs)
+			}
+		})
+	}
+}
+
+func TestDeployQueueClaimDueJob(t *testing.T) {
+	q, repository, _ := newTestDeployQueue(nil)
+	job, _ := q.Enqueue(deployEvent("app", "1.0"))
+
+	claimed, err := q.claimDueJob()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claimed == nil || claimed.ID != job.ID {
+		t.Fatalf("claimDueJob() = %v, want job %s", claimed, job.ID.Hex())
+	}
+	if stored := repository.items[job.ID.Hex()]; stored.Status != domain.DeployJobStatusRunning || stored.Attempts != 1 {
+		t.Errorf("claimed job = %+v, want running job with one attempt", stored)
+	}
+
+	// a newer event of the running release waits until the running job is finished
+	if _, err := q.Enqueue(deployEvent("app", "1.1")); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := q.claimDueJob(); next != nil {
+		t.Errorf("claimDueJob() = %s while the release is running, want nil", next.ID.Hex())
+	}
+	q.unlockRelease("default/app")
+	if next, _ := q.claimDueJob(); next == nil || next.ImageTag != "1.1" {
+		t.Errorf("claimDueJob() = %v after the release is unlocked, want job of the newer event", next)
+	}
+}
+
+func TestDeployQueueOrdersJobsPerRelease(t *testing.T) {
+	q, _, _ := newTestDeployQueue(nil)
+	dev := domain.DeployEvent{DeployConfig: domain.DeployConfig{ReleaseName: "app", Namespace: "dev"}, ImageTag: "1.0"}
+	prod := domain.DeployEvent{DeployConfig: domain.DeployConfig{ReleaseName: "app", Namespace: "prod"}, ImageTag: "2.0"}
+	for _, event := range []domain.DeployEvent{dev, prod} {
+		if _, err := q.Enqueue(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the release in another namespace has its own lane
+	first, _ := q.claimDueJob()
+	second, _ := q.claimDueJob()
+	if first == nil || second == nil {
+		t.Fatalf("claimDueJob() = %v, %v, want jobs of both namespaces", first, second)
+	}
+	if first.DeployConfig.Namespace != "dev" || second.DeployConfig.Namespace != "prod" {
+		t.Errorf("claimed namespaces %s, %s, want dev, prod in queue order", first.DeployConfig.Namespace, second.DeployConfig.Namespace)
+	}
+
+	// newer events wait for the running job of their release only
+	newer := dev
+	newer.ImageTag = "1.1"
+	if _, err := q.Enqueue(newer); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := q.claimDueJob(); next != nil {
+		t.Errorf("claimDueJob() = %v while the release is running, want nil", next)
+	}
+	q.unlockRelease(releaseKey(prod.DeployConfig))
+	if next, _ := q.claimDueJob(); next != nil {
+		t.Errorf("claimDueJob() = %v after other namespace is unlocked, want nil", next)
+	}
+	q.unlockRelease(releaseKey(dev.DeployConfig))
+	if next, _ := q.claimDueJob(); next == nil || next.ImageTag != "1.1" || next.DeployConfig.Namespace != "dev" {
+		t.Errorf("claimDueJob() = %v after the release is unlocked, want job of the newer event", next)
+	}
+}
+
+func TestDeployQueueRunTransitions(t *testing.T) {
+	deployErr := errors.New("upgrade failed")
+	tests := []struct {
+		name      string
+		deployErr error
+		attempts  int
+		// newer queues a newer event of the release while the job is running
+		newer      bool
+		wantStatus string
+	}{
+		{name: "success removes job", attempts: 1},
+		{name: "failure schedules retry", deployErr: deployErr, attempts: 1, wantStatus: domain.DeployJobStatusPending},
+		{name: "last attempt moves job to dead", deployErr: deployErr, attempts: 2, wantStatus: domain.DeployJobStatusDead},
+		{name: "failure superseded by newer job", deployErr: deployErr, attempts: 1, newer: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, repository, deploymentService := newTestDeployQueue(tt.deployErr)
+			job, _ := q.Enqueue(deployEvent("app", "1.0"))
+			claimed, _ := q.claimDueJob()
+			claimed.Attempts = tt.attempts
+			if tt.newer {
+				if _, err := q.Enqueue(deployEvent("app", "1.1")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			before := time.Now()
+			q.run(context.Background(), claimed)
+
+			if len(deploymentService.events) != 1 || deploymentService.events[0].ImageTag != "1.0" {
+				t.Errorf("deployed events = %+v, want the event of the job", deploymentService.events)
+			}
+			if q.running["default/app"] {
+				t.Error("release is still locked after the job has run")
+			}
+			stored, ok := repository.items[job.ID.Hex()]
+			if tt.wantStatus == "" {
+				if ok {
+					t.Errorf("job %+v was kept, want it removed", stored)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("job was removed")
+			}
+			if stored.Status != tt.wantStatus || stored.LastError != deployErr.Error() {
+				t.Errorf("job = %+v, want status %s with the deploy error", stored, tt.wantStatus)
+			}
+			if tt.wantStatus == domain.DeployJobStatusPending && !stored.NextAttemptAt.After(before) {
+				t.Errorf("next attempt at %v, want a later attempt", stored.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestDeployQueueRetry(t *testing.T) {
+	q, repository, _ := newTestDeployQueue(nil)
+	job, _ := q.Enqueue(deployEvent("app", "1.0"))
+	if _, err := q.Retry(job.ID.Hex()); err == nil {
+		t.Error("Retry() of a pending job succeeded, want error")
+	}
+
+	dead := repository.items[job.ID.Hex()]
+	dead.Status = domain.DeployJobStatusDead
+	dead.Attempts = 2
+	repository.items[job.ID.Hex()] = dead
+	retried, err := q.Retry(job.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Status != domain.DeployJobStatusPending || retried.Attempts != 0 {
+		t.Errorf("Retry() = %+v, want pending job without attempts", retried)
+	}
+	if _, err := q.Retry(bson.NewObjectId().Hex()); err == nil {
+		t.Error("Retry() of an unknown job succeeded, want error")
+	}
+}
+
+func TestDeployQueueResume(t *testing.T) {
+	q, repository, _ := newTestDeployQueue(nil)
+	job, _ := q.Enqueue(deployEvent("app", "1.0"))
+	if _, err := q.claimDueJob(); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.resume(); err != nil {
+		t.Fatal(err)
+	}
+	if stored := repository.items[job.ID.Hex()]; stored.Status != domain.DeployJobStatusPending {
+		t.Errorf("resumed job status = %s, want %s", stored.Status, domain.DeployJobStatusPending)
+	}
+	if err := q.Delete(job.ID.Hex()); err != nil {
+		t.Errorf("Delete() of a pending job = %v", err)
+	}
+}
+
+func TestDeployQueueBackoff(t *testing.T) {
+	q, _, _ := newTestDeployQueue(nil)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{attempts: 1, want: time.Minute},
+		{attempts: 2, want: 2 * time.Minute},
+		{attempts: 4, want: 8 * time.Minute},
+		{attempts: 10, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := q.backoff(tt.attempts); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempts, got, tt.want)
+		}
+	}
+}
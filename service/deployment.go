@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+//DeploymentServiceImpl is an implementation of the DeploymentService interface
+type DeploymentServiceImpl struct {
+	Repository      domain.DeploymentRepository
+	HelmService     domain.HelmService
+	ReleaseProvider domain.K8SReleaseProvider
+	RolloutTimeout  time.Duration
+	Retention       time.Duration
+}
+
+//NewDeploymentService returns a new instance of DeploymentService.
+//Deploys succeed only after Deployments of the release are rolled out within rolloutTimeout,
+//deployments older than retention are removed from the history, they are kept forever if retention is zero
+func NewDeploymentService(repository domain.DeploymentRepository, helmService domain.HelmService,
+	releaseProvider domain.K8SReleaseProvider, rolloutTimeout, retention time.Duration) domain.DeploymentService {
+	return &DeploymentServiceImpl{
+		Repository:      repository,
+		HelmService:     helmService,
+		ReleaseProvider: releaseProvider,
+		RolloutTimeout:  rolloutTimeout,
+		Retention:       retention,
+	}
+}
+
+//FindAll returns Deployments matching the filter, newest first
+func (c *DeploymentServiceImpl) FindAll(filter domain.DeploymentFilter) ([]domain.Deployment, error) {
+	return c.Repository.FindAll(filter)
+}
+
+//Count returns the number of Deployments matching release and status of the filter
+func (c *DeploymentServiceImpl) Count(filter domain.DeploymentFilter) (int, error) {
+	return c.Repository.Count(filter)
+}
+
+//FindOne returns Deployment by its id
+func (c *DeploymentServiceImpl) FindOne(id string) (*domain.Deployment, error) {
+	return c.Repository.FindOne(id)
+}
+
+//Deploy deploys the chart of the event and records the deployment
+func (c *DeploymentServiceImpl) Deploy(ctx context.Context, event domain.DeployEvent) (*domain.Deployment, error) {
+	logger := logging.FromContext(ctx)
+	cfg := event.DeployConfig
+	item := &domain.Deployment{
+		ReleaseName:    cfg.ReleaseName,
+		ChartName:      cfg.ChartName,
+		ChartVersion:   cfg.ChartVersion,
+		ChartValuesID:  cfg.ChartValuesID,
+		Trigger:        event.Trigger,
+		PayloadSummary: event.TriggerSummary,
+		Status:         domain.DeploymentStatusRunning,
+		StartedAt:      time.Now(),
+	}
+	if _, err := c.Repository.Save(item); err != nil {
+		return nil, err
+	}
+
+	result, deployErr := c.HelmService.DeployChart(ctx, cfg, event.ImageTag)
+	if deployErr == nil {
+		deployErr = c.ReleaseProvider.WaitForRollout(ctx, cfg.ReleaseName, result.Namespace, c.RolloutTimeout)
+		if rolloutErr, ok := deployErr.(*domain.RolloutError); ok {
+			item.RolloutFailures = rolloutErr.Reasons
+		}
+	}
+	testsFailed := false
+	if deployErr == nil && cfg.Tests != nil {
+		item.TestResults, deployErr = c.runTests(ctx, cfg)
+		testsFailed = deployErr != nil
+	}
+	finishedAt := time.Now()
+	item.FinishedAt = &finishedAt
+	if result != nil {
+		item.ChartVersion = result.ChartVersion
+		item.Revision = result.Revision
+	}
+	item.Status = domain.DeploymentStatusSuccess
+	if deployErr != nil {
+		item.Status = domain.DeploymentStatusFailed
+		item.Error = deployErr.Error()
+		if cfg.Atomic || testsFailed && cfg.Tests.Rollback {
+			c.rollback(ctx, cfg, item, result)
+		}
+	}
+	if _, err := c.Repository.Save(item); err != nil {
+		logger.WithFields(log.Fields{
+			"release": item.ReleaseName,
+			"error":   err,
+		}).Error("could not save deployment")
+	}
+	c.prune(ctx)
+	return item, deployErr
+}
+
+//DryRun renders the upgrade the deploy would make and compares it with the deployed release
+func (c *DeploymentServiceImpl) DryRun(ctx context.Context, cfg domain.DeployConfig) (*domain.ReleaseDiff, error) {
+	rls, err := c.HelmService.DryRunDeployChart(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return diffRelease(ctx, c.HelmService, rls.GetRelease())
+}
+
+//prune removes deployments older than the retention from the history
+func (c *DeploymentServiceImpl) prune(ctx context.Context) {
+	if c.Retention == 0 {
+		return
+	}
+	count, err := c.Repository.DeleteStartedBefore(time.Now().Add(-c.Retention))
+	if err != nil {
+		logging.FromContext(ctx).WithField("error", err).Error("could not remove old deployments")
+		return
+	}
+	if count > 0 {
+		logging.FromContext(ctx).WithField("count", count).Debug("removed old deployments")
+	}
+}
+
+//rollback restores the revision deployed before the failed deploy with upgrade options of the DeployConfig and records the outcome
+func (c *DeploymentServiceImpl) rollback(ctx context.Context, cfg domain.DeployConfig, item *domain.Deployment, result *domain.DeployResult) {
+	logger := logging.FromContext(ctx).WithField("release", item.ReleaseName)
+	if result == nil || result.Revision == 0 || result.PreviousRevision == 0 {
+		logger.Info("release was not changed, skipping rollback")
+		return
+	}
+	logger = logger.WithField("revision", result.PreviousRevision)
+	logger.Info("rolling back failed release")
+	if _, err := c.HelmService.RollbackRelease(ctx, item.ReleaseName, result.PreviousRevision, cfg.UpgradeOptions); err != nil {
+		logger.WithField("error", err).Error("could not roll back release")
+		item.RollbackError = err.Error()
+		return
+	}
+	item.RolledBackTo = result.PreviousRevision
+}
+
+//runTests runs test hooks of the deployed release and collects their results with the logs of test pods
+func (c *DeploymentServiceImpl) runTests(ctx context.Context, cfg domain.DeployConfig) ([]domain.TestResult, error) {
+	logger := logging.FromContext(ctx).WithField("release", cfg.ReleaseName)
+	rls, err := c.HelmService.RunReleaseTest(ctx, cfg.ReleaseName, cfg.Tests.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	var results []domain.TestResult
+	var failed []string
+	for _, run := range rls.GetInfo().GetStatus().GetLastTestSuiteRun().GetResults() {
+		result := domain.TestResult{
+			Name:   run.Name,
+			Status: run.Status.String(),
+			Info:   run.Info,
+		}
+		if run.StartedAt != nil {
+			startedAt, _ := ptypes.Timestamp(run.StartedAt)
+			result.StartedAt = &startedAt
+		}
+		if run.CompletedAt != nil {
+			completedAt, _ := ptypes.Timestamp(run.CompletedAt)
+			result.CompletedAt = &completedAt
+		}
+		result.Logs, err = c.ReleaseProvider.GetPodLogs(rls.Namespace, run.Name)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"test":  run.Name,
+				"error": err,
+			}).Warn("could not get test pod logs")
+		}
+		if run.Status != release.TestRun_SUCCESS {
+			failed = append(failed, run.Name)
+		}
+		results = append(results, result)
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("release tests failed: %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/globalsign/mgo/bson"
+)
+
+var deploymentsBucket = []byte("deployments")
+
+//BoltDeploymentRepository is an implementation of the DeploymentRepository interface which uses BoltDB
+type BoltDeploymentRepository struct {
+	db *bolt.DB
+}
+
+//NewDeploymentRepository returns a new instance of the DeploymentRepository
+func NewDeploymentRepository(db *bolt.DB) (domain.DeploymentRepository, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deploymentsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltDeploymentRepository{db}, nil
+}
+
+//FindAll returns Deployments matching the filter, newest first.
+//Ids of Deployments grow with the time they were started, so the bucket is read backwards from the Before cursor
+func (r *BoltDeploymentRepository) FindAll(filter domain.DeploymentFilter) ([]domain.Deployment, error) {
+	items := make([]domain.Deployment, 0)
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(deploymentsBucket).Cursor()
+		k, v := c.Last()
+		if filter.Before != "" {
+			k, v = c.Seek([]byte(filter.Before))
+			if k == nil {
+				k, v = c.Last()
+			}
+			for k != nil && bytes.Compare(k, []byte(filter.Before)) >= 0 {
+				k, v = c.Prev()
+			}
+		}
+		for ; k != nil; k, v = c.Prev() {
+			item, err := decodeDeployment(v)
+			if err != nil {
+				return err
+			}
+			if !matchDeploymentFilter(item, filter) {
+				continue
+			}
+			items = append(items, *item)
+			if filter.Limit > 0 && len(items) >= filter.Limit {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+//Count returns the number of Deployments matching release and status of the filter, paging is ignored
+func (r *BoltDeploymentRepository) Count(filter domain.DeploymentFilter) (int, error) {
+	count := 0
+	err := r.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deploymentsBucket)
+		if filter.ReleaseName == "" && filter.Status == "" {
+			count = b.Stats().KeyN
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			item, err := decodeDeployment(v)
+			if err != nil {
+				return err
+			}
+			if matchDeploymentFilter(item, filter) {
+				count++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+//matchDeploymentFilter returns true if the Deployment has release and status of the filter
+func matchDeploymentFilter(item *domain.Deployment, filter domain.DeploymentFilter) bool {
+	if filter.ReleaseName != "" && item.ReleaseName != filter.ReleaseName {
+		return false
+	}
+	return filter.Status == "" || item.Status == filter.Status
+}
+
+//FindOne returns a Deployment by its id
+func (r *BoltDeploymentRepository) FindOne(id string) (*domain.Deployment, error) {
+	var item *domain.Deployment
+	err := r.db.View(func(tx *bolt.Tx) error {
+		var err error
+		b := tx.Bucket(deploymentsBucket)
+		itemData := b.Get([]byte(id))
+		if len(itemData) == 0 {
+			return nil
+		}
+		item, err = decodeDeployment(itemData)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+//Save persists Deployment to the database
+func (r *BoltDeploymentRepository) Save(item *domain.Deployment) (*domain.Deployment, error) {
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deploymentsBucket)
+		if item.ID == "" {
+			item.ID = bson.NewObjectId()
+		}
+		enc, err := encodeDeployment(item)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(item.ID.Hex()), enc)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return item, nil
+}
+
+//DeleteStartedBefore removes Deployments started before t and returns the number of removed Deployments
+func (r *BoltDeploymentRepository) DeleteStartedBefore(t time.Time) (int, error) {
+	var keys [][]byte
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(deploymentsBucket)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			item, err := decodeDeployment(v)
+			if err != nil {
+				return err
+			}
+			if !item.StartedAt.Before(t) {
+				break
+			}
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
+
+func encodeDeployment(p *domain.Deployment) ([]byte, error) {
+	enc, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return enc, nil
+}
+
+func decodeDeployment(data []byte) (*domain.Deployment, error) {
+	var item *domain.Deployment
+	err := json.Unmarshal(data, &item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/bbolt"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/globalsign/mgo/bson"
+)
+
+func newTestDeploymentRepository(t *testing.T) domain.DeploymentRepository {
+	dir, err := ioutil.TempDir("", "deployments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	repository, err := NewDeploymentRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repository
+}
+
+//saveTestDeployments saves a deployment for every release, started one hour after another
+func saveTestDeployments(t *testing.T, repository domain.DeploymentRepository, start time.Time, releases ...string) []domain.Deployment {
+	var items []domain.Deployment
+	for i, releaseName := range releases {
+		startedAt := start.Add(time.Duration(i) * time.Hour)
+		item := &domain.Deployment{
+			ID:          bson.NewObjectIdWithTime(startedAt),
+			ReleaseName: releaseName,
+			Status:      domain.DeploymentStatusSuccess,
+			StartedAt:   startedAt,
+		}
+		if _, err := repository.Save(item); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, *item)
+	}
+	return items
+}
+
+func deploymentIDs(items []domain.Deployment) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID.Hex())
+	}
+	return ids
+}
+
+func TestDeploymentRepositoryFindAll(t *testing.T) {
+	repository := newTestDeploymentRepository(t)
+	items := saveTestDeployments(t, repository, time.Now().Add(-24*time.Hour), "app", "api", "app", "app", "api")
+	ids := deploymentIDs(items)
+
+	tests := []struct {
+		name   string
+		filter domain.DeploymentFilter
+		want   []string
+	}{
+		{name: "all newest first", want: []string{ids[4], ids[3], ids[2], ids[1], ids[0]}},
+		{name: "first page", filter: domain.DeploymentFilter{Limit: 2}, want: []string{ids[4], ids[3]}},
+		{name: "next page", filter: domain.DeploymentFilter{Before: ids[3], Limit: 2}, want: []string{ids[2], ids[1]}},
+		{name: "last page", filter: domain.DeploymentFilter{Before: ids[1], Limit: 2}, want: []string{ids[0]}},
+		{name: "before unknown newer id", filter: domain.DeploymentFilter{Before: bson.NewObjectId().Hex(), Limit: 1}, want: []string{ids[4]}},
+		{name: "release", filter: domain.DeploymentFilter{ReleaseName: "app"}, want: []string{ids[3], ids[2], ids[0]}},
+		{name: "release page", filter: domain.DeploymentFilter{ReleaseName: "app", Before: ids[3], Limit: 1}, want: []string{ids[2]}},
+		{name: "status", filter: domain.DeploymentFilter{Status: domain.DeploymentStatusFailed}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repository.FindAll(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotIDs := deploymentIDs(got)
+			if len(gotIDs) != len(tt.want) {
+				t.Fatalf("FindAll() = %v, want %v", gotIDs, tt.want)
+			}
+			for i := range gotIDs {
+				if gotIDs[i] != tt.want[i] {
+					t.Fatalf("FindAll() = %v, want %v", gotIDs, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestDeploymentRepositoryDeleteStartedBefore(t *testing.T) {
+	repository := newTestDeploymentRepository(t)
+	start := time.Now().Add(-24 * time.Hour)
+	items := saveTestDeployments(t, repository, start, "app", "api", "app")
+
+	count, err := repository.DeleteStartedBefore(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("DeleteStartedBefore() = %d, want 2", count)
+	}
+	left, err := repository.FindAll(domain.DeploymentFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 1 || left[0].ID != items[2].ID {
+		t.Errorf("FindAll() after DeleteStartedBefore() = %v, want [%s]", deploymentIDs(left), items[2].ID.Hex())
+	}
+}
+
+func TestDeploymentRepositoryCount(t *testing.T) {
+	repository := newTestDeploymentRepository(t)
+	saveTestDeployments(t, repository, time.Now().Add(-24*time.Hour), "app", "api", "app", "app", "api")
+
+	tests := []struct {
+		name   string
+		filter domain.DeploymentFilter
+		want   int
+	}{
+		{name: "all", want: 5},
+		{name: "paging is ignored", filter: domain.DeploymentFilter{Before: bson.NewObjectId().Hex(), Limit: 2}, want: 5},
+		{name: "release", filter: domain.DeploymentFilter{ReleaseName: "app", Limit: 1}, want: 3},
+		{name: "status", filter: domain.DeploymentFilter{Status: domain.DeploymentStatusFailed}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repository.Count(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Count() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,8 +2,8 @@ package service
 
 import (
 	"context"
+	"fmt"
 	"net/http"
-	"sync"
 
 	"github.com/entwico/helm-deployer/conf/logging"
 	"github.com/entwico/helm-deployer/domain"
@@ -12,16 +12,27 @@ import (
 )
 
 type webhookProcessor struct {
-	helmService domain.HelmService
+	deployQueue domain.DeployQueue
 	processors  []domain.WebhookProcessor
 }
 
 //NewWebhookDispatcher returns a new instance of WebhookProcessor
-func NewWebhookDispatcher(helmService domain.HelmService, processors []domain.WebhookProcessor) domain.WebhookDispatcher {
-	return &webhookProcessor{helmService: helmService, processors: processors}
+func NewWebhookDispatcher(deployQueue domain.DeployQueue, processors []domain.WebhookProcessor) domain.WebhookDispatcher {
+	return &webhookProcessor{deployQueue: deployQueue, processors: processors}
 }
 
+//GetWebhookProcessor returns the processor of the source of the Webhook attached to the context.
+//Webhooks sent to the global route or to a Webhook without source are detected by their content,
+//their processor accepts them only if they are verified with the secret of the route
 func (c *webhookProcessor) GetWebhookProcessor(ctx context.Context, headers http.Header, body []byte) (domain.WebhookProcessor, error) {
+	if w := domain.WebhookFromContext(ctx); w != nil && w.Source != "" {
+		for _, processor := range c.processors {
+			if processor.Source() == w.Source {
+				return processor, nil
+			}
+		}
+		return nil, fmt.Errorf("webhook %s has unsupported source '%s'", w.Name, w.Source)
+	}
 	for _, processor := range c.processors {
 		if processor.CanProcess(ctx, headers, body) {
 			return processor, nil
@@ -31,51 +42,33 @@ func (c *webhookProcessor) GetWebhookProcessor(ctx context.Context, headers http
 	return nil, errors.New("could not find suitable WebhookProcessor")
 }
 
-func (c *webhookProcessor) StartHandleDeployConfigEvents(ctx context.Context) {
+//Dispatch queues deploy jobs for the events, it returns once every job is persisted
+func (c *webhookProcessor) Dispatch(ctx context.Context, events []domain.DeployEvent) ([]domain.DeployJob, error) {
 	logger := logging.FromContext(ctx)
-	chans := make([]<-chan domain.DeployConfig, 0)
-	for _, p := range c.processors {
-		chans = append(chans, p.GetDeployConfigEvents(ctx))
-	}
-	out := getDeployConfigsChan(chans...)
-
-	for {
-		select {
-		case cfg := <-out:
+	jobs := make([]domain.DeployJob, 0, len(events))
+	for _, event := range events {
+		cfg := event.DeployConfig
+		logger.WithFields(log.Fields{
+			"release":       cfg.ReleaseName,
+			"chart_name":    cfg.ChartName,
+			"chart_version": cfg.ChartVersion,
+			"trigger":       event.Trigger,
+		}).Info("queueing release update")
+		job, err := c.deployQueue.Enqueue(event)
+		if err != nil {
 			logger.WithFields(log.Fields{
 				"release":       cfg.ReleaseName,
 				"chart_name":    cfg.ChartName,
 				"chart_version": cfg.ChartVersion,
-			}).Info("updating release")
-			if err := c.helmService.DeployChart(ctx, cfg); err != nil {
-				logger.WithFields(log.Fields{
-					"chart_name": cfg.ChartName,
-					"error":      cfg.ChartVersion,
-				}).Error("could not deploy chart")
-				continue
-			}
-			logger.WithField("release", cfg.ReleaseName).Debug("release updated")
+				"error":         err,
+			}).Error("could not queue deploy job")
+			return jobs, errors.Wrapf(err, "could not queue deploy of release %s", cfg.ReleaseName)
 		}
+		logger.WithFields(log.Fields{
+			"release": cfg.ReleaseName,
+			"job_id":  job.ID.Hex(),
+		}).Debug("deploy job queued")
+		jobs = append(jobs, *job)
 	}
-}
-
-func getDeployConfigsChan(chans ...<-chan domain.DeployConfig) <-chan domain.DeployConfig {
-	out := make(chan domain.DeployConfig)
-	go func() {
-		var wg sync.WaitGroup
-		wg.Add(len(chans))
-
-		for _, c := range chans {
-			go func(c <-chan domain.DeployConfig) {
-				for v := range c {
-					out <- v
-				}
-				wg.Done()
-			}(c)
-		}
-
-		wg.Wait()
-		close(out)
-	}()
-	return out
+	return jobs, nil
 }
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestGetWebhookProcessor(t *testing.T) {
+	logger := log.NewEntry(log.New())
+	processors := []domain.WebhookProcessor{
+		NewGitlabProcessor(nil, "token"),
+		NewGithubProcessor(nil, "secret", "", ""),
+		NewRegistryProcessor(nil, "", logger),
+		NewHarborProcessor(nil, "", logger),
+	}
+	dispatcher := NewWebhookDispatcher(nil, processors)
+
+	registryHeaders := http.Header{headerContentType: []string{registryEventsMediaType}}
+	harborBody := []byte(`{"type":"PUSH_ARTIFACT","event_data":{"repository":{"repo_full_name":"library/app"}}}`)
+	tests := []struct {
+		name    string
+		webhook *domain.Webhook
+		headers http.Header
+		body    []byte
+		source  string
+	}{
+		{name: "global route detects gitlab", headers: http.Header{headerWebhookGitlab: []string{"Pipeline Hook"}}, source: domain.TriggerGitlab},
+		{name: "global route detects registry", headers: registryHeaders, source: domain.TriggerRegistry},
+		{name: "named route uses webhook source", webhook: &domain.Webhook{Name: "app", Source: domain.TriggerGithub},
+			headers: registryHeaders, body: harborBody, source: domain.TriggerGithub},
+		{name: "named route ignores headers of other sources", webhook: &domain.Webhook{Name: "app", Source: domain.TriggerGitlab},
+			headers: http.Header{headerWebhookGithub: []string{"push"}}, source: domain.TriggerGitlab},
+		{name: "named route without source detects content", webhook: &domain.Webhook{Name: "app"}, headers: registryHeaders,
+			source: domain.TriggerRegistry},
+		{name: "unknown content", headers: http.Header{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.webhook != nil {
+				ctx = domain.NewContextWithWebhook(ctx, tt.webhook)
+			}
+			p, err := dispatcher.GetWebhookProcessor(ctx, tt.headers, tt.body)
+			if tt.source == "" {
+				if err == nil {
+					t.Errorf("GetWebhookProcessor() returned %s processor, want error", p.Source())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("GetWebhookProcessor() error = %v", err)
+			}
+			if p.Source() != tt.source {
+				t.Errorf("GetWebhookProcessor() = %s processor, want %s", p.Source(), tt.source)
+			}
+		})
+	}
+}
+
+func TestVerifyWithoutSecret(t *testing.T) {
+	logger := log.NewEntry(log.New())
+	processors := []domain.WebhookProcessor{
+		NewGitlabProcessor(nil, ""),
+		NewGithubProcessor(nil, "", "", ""),
+		NewNexusProcessor(nil, "", logger),
+		NewRegistryProcessor(nil, "", logger),
+		NewHarborProcessor(nil, "", logger),
+	}
+	for _, p := range processors {
+		if err := p.Verify(context.Background(), http.Header{}, []byte(`{}`)); err != ErrNoWebhookSecret {
+			t.Errorf("%s processor Verify() = %v, want %v", p.Source(), err, ErrNoWebhookSecret)
+		}
+	}
+}
+
+//failingDeployQueue fails to queue events of the release
+type failingDeployQueue struct {
+	*deployQueueImpl
+	release string
+}
+
+func (q *failingDeployQueue) Enqueue(event domain.DeployEvent) (*domain.DeployJob, error) {
+	if event.DeployConfig.ReleaseName == q.release {
+		return nil, errors.New("database is closed")
+	}
+	return q.deployQueueImpl.Enqueue(event)
+}
+
+func TestDispatch(t *testing.T) {
+	events := []domain.DeployEvent{deployEvent("app", "1.0"), deployEvent("api", "1.0")}
+	tests := []struct {
+		name     string
+		failing  string
+		wantJobs int
+		wantErr  bool
+	}{
+		{name: "all events queued", wantJobs: 2},
+		{name: "queue failure", failing: "api", wantJobs: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, repository, _ := newTestDeployQueue(nil)
+			dispatcher := NewWebhookDispatcher(&failingDeployQueue{deployQueueImpl: q, release: tt.failing}, nil)
+			jobs, err := dispatcher.Dispatch(context.Background(), events)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Dispatch() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(jobs) != tt.wantJobs || len(repository.items) != tt.wantJobs {
+				t.Errorf("Dispatch() queued %d jobs, stored %d, want %d", len(jobs), len(repository.items), tt.wantJobs)
+			}
+		})
+	}
+}
@@ -3,29 +3,55 @@ package service
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"io"
+	"regexp"
+	"strings"
 
 	"github.com/entwico/helm-deployer/conf/logging"
 	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/status"
+	coreV1 "k8s.io/api/core/v1"
 	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/release"
 	"k8s.io/helm/pkg/proto/hapi/services"
 )
 
+const (
+	//maxReleaseHistory limits the number of revisions read from Tiller
+	maxReleaseHistory = 256
+	//defaultHelmTimeout is the timeout in seconds used by Helm client for Kubernetes operations
+	defaultHelmTimeout = 300
+)
+
+//existingReleaseStatuses are the statuses of releases which can be upgraded
+var existingReleaseStatuses = []release.Status_Code{
+	release.Status_UNKNOWN,
+	release.Status_DEPLOYED,
+	release.Status_SUPERSEDED,
+	release.Status_FAILED,
+	release.Status_DELETING,
+	release.Status_PENDING_INSTALL,
+	release.Status_PENDING_UPGRADE,
+	release.Status_PENDING_ROLLBACK,
+}
+
 //helmServiceImpl is an implementation of HelmService interface
 type helmServiceImpl struct {
-	client                 *helm.Client
-	chartValuesService     domain.ChartValuesService
-	chartRepositoryService domain.ChartRepositoryService
+	client             *helm.Client
+	chartValuesService domain.ChartValuesService
+	chartRepositories  domain.ChartRepositoryRegistry
 }
 
 //NewHelmService returns a new instance of HelmService
-func NewHelmService(client *helm.Client, chartValuesService domain.ChartValuesService, chartRepositoryService domain.ChartRepositoryService) domain.HelmService {
+func NewHelmService(client *helm.Client, chartValuesService domain.ChartValuesService, chartRepositories domain.ChartRepositoryRegistry) domain.HelmService {
 	return &helmServiceImpl{
-		client:                 client,
-		chartValuesService:     chartValuesService,
-		chartRepositoryService: chartRepositoryService,
+		client:             client,
+		chartValuesService: chartValuesService,
+		chartRepositories:  chartRepositories,
 	}
 }
 
@@ -38,8 +64,43 @@ func (s *helmServiceImpl) ListReleases(ctx context.Context) (*services.ListRelea
 	return response, nil
 }
 
+//ReleaseContent returns the latest revision of the release
+func (s *helmServiceImpl) ReleaseContent(ctx context.Context, rlsName string) (*services.GetReleaseContentResponse, error) {
+	r, err := s.client.ReleaseContent(rlsName)
+	return r, releaseNotFound(err, rlsName)
+}
+
+//ReleaseHistory returns all revisions of the release, newest first
+func (s *helmServiceImpl) ReleaseHistory(ctx context.Context, rlsName string) (*services.GetHistoryResponse, error) {
+	r, err := s.client.ReleaseHistory(rlsName, helm.WithMaxHistory(maxReleaseHistory))
+	return r, releaseNotFound(err, rlsName)
+}
+
+//releaseNotFound converts errors Tiller returns for missing releases into ReleaseNotFoundError
+func releaseNotFound(err error, rlsName string) error {
+	if err == nil {
+		return nil
+	}
+	msg := status.Convert(err).Message()
+	if msg == fmt.Sprintf("release: %q not found", rlsName) || msg == fmt.Sprintf("no revision for release %q", rlsName) {
+		return &domain.ReleaseNotFoundError{Release: rlsName}
+	}
+	return err
+}
+
 //UpdateRelease updates helm release
 func (s *helmServiceImpl) UpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error) {
+	return s.updateRelease(ctx, rlsName, chartData, rawVals, upgradeOptions(domain.DefaultUpgradeOptions)...)
+}
+
+//DryRunUpdateRelease renders the release update without applying it
+func (s *helmServiceImpl) DryRunUpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error) {
+	opts := append(upgradeOptions(domain.DefaultUpgradeOptions), helm.UpgradeDryRun(true))
+	r, err := s.updateRelease(ctx, rlsName, chartData, rawVals, opts...)
+	return r, releaseNotFound(err, rlsName)
+}
+
+func (s *helmServiceImpl) updateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte, opts ...helm.UpdateOption) (*services.UpdateReleaseResponse, error) {
 	logger := logging.FromContext(ctx)
 	chart, err := chartutil.LoadArchive(chartData)
 	if err != nil {
@@ -47,33 +108,319 @@ func (s *helmServiceImpl) UpdateRelease(ctx context.Context, rlsName string, cha
 	}
 	logger.WithField("chart_name", chart.Metadata.Name).Debug("chart loaded")
 	logger.WithField("release", rlsName).Debug("updating release")
-	return s.client.UpdateReleaseFromChart(rlsName, chart, helm.UpdateValueOverrides(rawVals), helm.UpgradeForce(true), helm.UpgradeRecreate(true))
+	opts = append([]helm.UpdateOption{helm.UpdateValueOverrides(rawVals)}, opts...)
+	return s.client.UpdateReleaseFromChart(rlsName, chart, opts...)
+}
+
+//DeployChart deploys the helm chart.
+//The result is returned on failure as well, as far as the deploy got
+func (s *helmServiceImpl) DeployChart(ctx context.Context, cfg domain.DeployConfig, imageTag string) (*domain.DeployResult, error) {
+	result := &domain.DeployResult{ChartVersion: cfg.ChartVersion}
+	deploy, err := prepareChartDeploy(ctx, cfg, imageTag, result, s.chartValuesService, s.chartRepositories)
+	if err != nil {
+		return result, err
+	}
+
+	exists, err := s.releaseExists(cfg.ReleaseName)
+	if err != nil {
+		return result, err
+	}
+	if !exists {
+		if !cfg.InstallIfMissing {
+			return result, &domain.ReleaseNotFoundError{Release: cfg.ReleaseName}
+		}
+		response, err := s.installRelease(ctx, cfg, deploy, deploy.installOptions()...)
+		if err != nil {
+			return result, err
+		}
+		result.Revision = response.GetRelease().GetVersion()
+		result.Namespace = response.GetRelease().GetNamespace()
+		return result, checkReleaseStatus(response.GetRelease())
+	}
+
+	logger := logging.FromContext(ctx)
+	deployed, last, err := s.releaseRevisions(cfg.ReleaseName)
+	if err != nil {
+		logger.WithField("error", err).Warn("could not read release history")
+	}
+	result.PreviousRevision = deployed
+
+	response, err := s.updateRelease(ctx, cfg.ReleaseName, bytes.NewReader(deploy.chartData), deploy.rawVals, deploy.updateOptions()...)
+	if err != nil {
+		if _, current, historyErr := s.releaseRevisions(cfg.ReleaseName); historyErr == nil && current > last {
+			result.Revision = current
+		}
+		return result, err
+	}
+	result.Revision = response.GetRelease().GetVersion()
+	result.Namespace = response.GetRelease().GetNamespace()
+	return result, checkReleaseStatus(response.GetRelease())
+}
+
+//DryRunDeployChart renders the upgrade the chart deploy would make without applying it
+func (s *helmServiceImpl) DryRunDeployChart(ctx context.Context, cfg domain.DeployConfig) (*services.UpdateReleaseResponse, error) {
+	deploy, err := prepareChartDeploy(ctx, cfg, "", new(domain.DeployResult), s.chartValuesService, s.chartRepositories)
+	if err != nil {
+		return nil, err
+	}
+	exists, err := s.releaseExists(cfg.ReleaseName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists && cfg.InstallIfMissing {
+		opts := append(deploy.installOptions(), helm.InstallDryRun(true))
+		response, err := s.installRelease(ctx, cfg, deploy, opts...)
+		if err != nil {
+			return nil, err
+		}
+		return &services.UpdateReleaseResponse{Release: response.GetRelease()}, nil
+	}
+	opts := append(deploy.updateOptions(), helm.UpgradeDryRun(true))
+	return s.updateRelease(ctx, cfg.ReleaseName, bytes.NewReader(deploy.chartData), deploy.rawVals, opts...)
+}
+
+func (s *helmServiceImpl) installRelease(ctx context.Context, cfg domain.DeployConfig, deploy *chartDeploy, opts ...helm.InstallOption) (*services.InstallReleaseResponse, error) {
+	logger := logging.FromContext(ctx)
+	chart, err := chartutil.LoadArchive(bytes.NewReader(deploy.chartData))
+	if err != nil {
+		return nil, err
+	}
+	namespace := releaseNamespace(cfg)
+	logger.WithFields(log.Fields{
+		"release":   cfg.ReleaseName,
+		"namespace": namespace,
+	}).Info("installing release")
+	opts = append([]helm.InstallOption{
+		helm.ReleaseName(cfg.ReleaseName),
+		helm.ValueOverrides(deploy.rawVals),
+		helm.InstallReuseName(true),
+	}, opts...)
+	return s.client.InstallReleaseFromChart(chart, namespace, opts...)
+}
+
+//releaseExists returns true if the release is known to Tiller and was not deleted
+func (s *helmServiceImpl) releaseExists(rlsName string) (bool, error) {
+	response, err := s.client.ListReleases(
+		helm.ReleaseListFilter("^"+regexp.QuoteMeta(rlsName)+"$"),
+		helm.ReleaseListStatuses(existingReleaseStatuses),
+	)
+	if err != nil {
+		return false, err
+	}
+	return len(response.GetReleases()) > 0, nil
+}
+
+//RollbackRelease rolls back the release to the given revision, zero means the previous one.
+//DefaultUpgradeOptions are used if options are nil
+func (s *helmServiceImpl) RollbackRelease(ctx context.Context, rlsName string, version int32, options *domain.UpgradeOptions) (*services.RollbackReleaseResponse, error) {
+	logger := logging.FromContext(ctx)
+	logger.WithFields(log.Fields{
+		"release":  rlsName,
+		"revision": version,
+	}).Debug("rolling back release")
+	opts := domain.DefaultUpgradeOptions
+	if options != nil {
+		opts = *options
+	}
+	return s.client.RollbackRelease(rlsName,
+		helm.RollbackVersion(version),
+		helm.RollbackForce(opts.Force),
+		helm.RollbackRecreate(opts.Recreate),
+		helm.RollbackWait(opts.Wait),
+		helm.RollbackTimeout(helmTimeout(opts)),
+		helm.RollbackDisableHooks(opts.DisableHooks),
+	)
 }
 
-//DeployChart deploys the helm chart
-func (s *helmServiceImpl) DeployChart(ctx context.Context, cfg domain.DeployConfig) error {
+//DeleteRelease deletes the release, its history is removed as well if purge is set
+func (s *helmServiceImpl) DeleteRelease(ctx context.Context, rlsName string, purge bool) (*services.UninstallReleaseResponse, error) {
 	logger := logging.FromContext(ctx)
 	logger.WithFields(log.Fields{
-		"chart_name":    cfg.ChartName,
-		"chart_version": cfg.ChartVersion,
+		"release": rlsName,
+		"purge":   purge,
+	}).Debug("deleting release")
+	r, err := s.client.DeleteRelease(rlsName, helm.DeletePurge(purge))
+	return r, releaseNotFound(err, rlsName)
+}
+
+//RunReleaseTest runs test hooks of the release and returns the release with results of the test run
+func (s *helmServiceImpl) RunReleaseTest(ctx context.Context, rlsName string, timeout int64) (*release.Release, error) {
+	logger := logging.FromContext(ctx).WithField("release", rlsName)
+	logger.Debug("running release tests")
+	if timeout == 0 {
+		timeout = defaultHelmTimeout
+	}
+	messages, errs := s.client.RunReleaseTest(rlsName, helm.ReleaseTestTimeout(timeout))
+	if messages != nil {
+		for msg := range messages {
+			logger.WithField("status", msg.Status.String()).Debug(msg.Msg)
+		}
+	}
+	if err := <-errs; err != nil {
+		return nil, errors.Wrap(err, "could not run release tests")
+	}
+	r, err := s.client.ReleaseContent(rlsName)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetRelease(), nil
+}
+
+//releaseRevisions returns the deployed and the latest revision of the release
+func (s *helmServiceImpl) releaseRevisions(rlsName string) (deployed, last int32, err error) {
+	response, err := s.client.ReleaseHistory(rlsName, helm.WithMaxHistory(maxReleaseHistory))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, rls := range response.Releases {
+		if rls.Version > last {
+			last = rls.Version
+		}
+		if rls.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED && rls.Version > deployed {
+			deployed = rls.Version
+		}
+	}
+	return deployed, last, nil
+}
+
+//checkReleaseStatus returns an error if the release ended up failed
+func checkReleaseStatus(rls *release.Release) error {
+	if rls.GetInfo().GetStatus().GetCode() == release.Status_FAILED {
+		return fmt.Errorf("release '%s' failed: %s", rls.GetName(), rls.GetInfo().GetDescription())
+	}
+	return nil
+}
+
+//releaseNamespace returns the namespace a missing release is installed into
+func releaseNamespace(cfg domain.DeployConfig) string {
+	if cfg.Namespace == "" {
+		return coreV1.NamespaceDefault
+	}
+	return cfg.Namespace
+}
+
+//chartDeploy holds the chart and values a release is updated with
+type chartDeploy struct {
+	chartData   []byte
+	rawVals     []byte
+	reuseValues bool
+	options     domain.UpgradeOptions
+}
+
+func (d *chartDeploy) updateOptions() []helm.UpdateOption {
+	return append(upgradeOptions(d.options), helm.ReuseValues(d.reuseValues))
+}
+
+func (d *chartDeploy) installOptions() []helm.InstallOption {
+	return []helm.InstallOption{
+		helm.InstallWait(d.options.Wait),
+		helm.InstallTimeout(helmTimeout(d.options)),
+		helm.InstallDisableHooks(d.options.DisableHooks),
+	}
+}
+
+//upgradeOptions converts UpgradeOptions into Helm client options
+func upgradeOptions(options domain.UpgradeOptions) []helm.UpdateOption {
+	return []helm.UpdateOption{
+		helm.UpgradeForce(options.Force),
+		helm.UpgradeRecreate(options.Recreate),
+		helm.UpgradeWait(options.Wait),
+		helm.UpgradeTimeout(helmTimeout(options)),
+		helm.ResetValues(options.ResetValues),
+		helm.ReuseValues(options.ReuseValues),
+		helm.UpgradeDisableHooks(options.DisableHooks),
+	}
+}
+
+func helmTimeout(options domain.UpgradeOptions) int64 {
+	if options.Timeout == 0 {
+		return defaultHelmTimeout
+	}
+	return options.Timeout
+}
+
+//prepareChartDeploy loads stored chart values, injects the image tag, resolves the chart version and downloads the chart.
+//Resolved chart version is written to the result
+func prepareChartDeploy(ctx context.Context, cfg domain.DeployConfig, imageTag string, result *domain.DeployResult,
+	chartValuesService domain.ChartValuesService, chartRepositories domain.ChartRepositoryRegistry) (*chartDeploy, error) {
+	logger := logging.FromContext(ctx)
+	logger.WithFields(log.Fields{
+		"chart_repository": cfg.Repository,
+		"chart_name":       cfg.ChartName,
+		"chart_version":    cfg.ChartVersion,
 	}).Debug("deploying chart")
+	deploy := &chartDeploy{options: cfg.GetUpgradeOptions()}
+	deploy.reuseValues = deploy.options.ReuseValues
 
-	var rawVals []byte
 	if cfg.ChartValuesID != nil {
-		values, err := s.chartValuesService.FindOne(*cfg.ChartValuesID)
+		values, err := chartValuesService.FindOne(*cfg.ChartValuesID)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if values != nil {
-			rawVals = []byte(values.Data)
+			deploy.rawVals = []byte(values.Data)
+		}
+	}
+
+	if cfg.ImageValuePath != "" && imageTag != "" {
+		vals, err := setValue(deploy.rawVals, cfg.ImageValuePath, imageTag)
+		if err != nil {
+			return nil, err
 		}
+		// keep values of the deployed release and override only the image tag
+		deploy.reuseValues = deploy.reuseValues || len(deploy.rawVals) == 0 && !deploy.options.ResetValues
+		deploy.rawVals = vals
+		logger.WithFields(log.Fields{
+			"release":          cfg.ReleaseName,
+			"image_value_path": cfg.ImageValuePath,
+			"image_tag":        imageTag,
+		}).Info("image tag injected into chart values")
 	}
 
-	data, err := s.chartRepositoryService.GetChartData(ctx, cfg.ChartName, cfg.ChartVersion)
+	chartRepository, err := chartRepositories.Repository(cfg.Repository, cfg.ChartName)
+	if err != nil {
+		return nil, err
+	}
+	chart, err := chartRepository.ResolveChart(ctx, cfg.ChartName, cfg.ChartVersion)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	result.ChartVersion = chart.Version
+	logger.WithFields(log.Fields{
+		"release":            cfg.ReleaseName,
+		"chart_name":         cfg.ChartName,
+		"chart_version":      cfg.ChartVersion,
+		"chart_version_used": chart.Version,
+	}).Info("chart version resolved")
 
-	_, err = s.UpdateRelease(ctx, cfg.ReleaseName, bytes.NewReader(data), rawVals)
-	return err
+	deploy.chartData, err = chartRepository.GetChartData(ctx, chart, cfg.Verify)
+	if err != nil {
+		return nil, err
+	}
+	return deploy, nil
+}
+
+//setValue sets the value at the dot separated path of the YAML encoded values
+func setValue(rawVals []byte, path, value string) ([]byte, error) {
+	vals, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, err
+	}
+	keys := strings.Split(path, ".")
+	current := map[string]interface{}(vals)
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+
+	data, err := vals.YAML()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
 }
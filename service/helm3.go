@@ -0,0 +1,726 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/engine"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/releaseutil"
+	"k8s.io/helm/pkg/timeconv"
+	"k8s.io/helm/pkg/version"
+)
+
+const (
+	notesFileSuffix = "NOTES.txt"
+	hookAnnotation  = "helm.sh/hook"
+)
+
+//recreatedKinds are workloads whose pods are deleted on upgrades with the recreate option
+var recreatedKinds = map[string]bool{
+	"Deployment":  true,
+	"DaemonSet":   true,
+	"StatefulSet": true,
+	"ReplicaSet":  true,
+}
+
+//installOrder is the order in which objects of a release are applied, kinds not listed go last
+var installOrder = []string{
+	"Namespace",
+	"ResourceQuota",
+	"LimitRange",
+	"PodSecurityPolicy",
+	"Secret",
+	"ConfigMap",
+	"StorageClass",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+	"ServiceAccount",
+	"CustomResourceDefinition",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"Role",
+	"RoleBinding",
+	"Service",
+	"DaemonSet",
+	"Pod",
+	"ReplicationController",
+	"ReplicaSet",
+	"Deployment",
+	"StatefulSet",
+	"Job",
+	"CronJob",
+	"Ingress",
+	"APIService",
+}
+
+//helm3ServiceImpl is an implementation of HelmService interface managing Helm 3 releases without Tiller.
+//Releases are stored in secrets of the release namespace, chart hooks are not run.
+//Releases are looked up by name in all namespaces unless the namespace is known from the DeployConfig
+type helm3ServiceImpl struct {
+	client             kubernetes.Interface
+	storage            *helm3ReleaseStorage
+	resources          *kubeResourceClient
+	chartValuesService domain.ChartValuesService
+	chartRepositories  domain.ChartRepositoryRegistry
+}
+
+//NewHelm3Service returns a new instance of HelmService talking directly to the Kubernetes API
+func NewHelm3Service(k8sConfigPath string, chartValuesService domain.ChartValuesService, chartRepositories domain.ChartRepositoryRegistry, logger *log.Entry) (domain.HelmService, error) {
+	config, err := getRestConfig(k8sConfigPath, logger)
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &helm3ServiceImpl{
+		client:  client,
+		storage: &helm3ReleaseStorage{client: client},
+		resources: &kubeResourceClient{
+			dynamicClient:   dynamicClient,
+			discoveryClient: client.Discovery(),
+		},
+		chartValuesService: chartValuesService,
+		chartRepositories:  chartRepositories,
+	}, nil
+}
+
+//ListReleases returns deployed revisions of all Helm releases
+func (s *helm3ServiceImpl) ListReleases(ctx context.Context) (*services.ListReleasesResponse, error) {
+	releases, err := s.storage.list(coreV1.NamespaceAll, labels.Set{"status": helm3StatusDeployed})
+	if err != nil {
+		return nil, err
+	}
+	latest := make(map[string]*helm3Release)
+	for _, rls := range releases {
+		key := rls.Namespace + "/" + rls.Name
+		if current, ok := latest[key]; !ok || current.Version < rls.Version {
+			latest[key] = rls
+		}
+	}
+
+	response := &services.ListReleasesResponse{Releases: make([]*release.Release, 0, len(latest))}
+	for _, rls := range latest {
+		item, err := rls.toProto()
+		if err != nil {
+			return nil, err
+		}
+		response.Releases = append(response.Releases, item)
+	}
+	sort.Slice(response.Releases, func(i, j int) bool {
+		return response.Releases[i].Name < response.Releases[j].Name
+	})
+	response.Count = int64(len(response.Releases))
+	response.Total = response.Count
+	return response, nil
+}
+
+//ReleaseContent returns the latest revision of the release
+func (s *helm3ServiceImpl) ReleaseContent(ctx context.Context, rlsName string) (*services.GetReleaseContentResponse, error) {
+	history, err := s.storage.history(rlsName, coreV1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &domain.ReleaseNotFoundError{Release: rlsName}
+	}
+	item, err := history[len(history)-1].toProto()
+	if err != nil {
+		return nil, err
+	}
+	return &services.GetReleaseContentResponse{Release: item}, nil
+}
+
+//ReleaseHistory returns all revisions of the release, newest first
+func (s *helm3ServiceImpl) ReleaseHistory(ctx context.Context, rlsName string) (*services.GetHistoryResponse, error) {
+	history, err := s.storage.history(rlsName, coreV1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &domain.ReleaseNotFoundError{Release: rlsName}
+	}
+	response := &services.GetHistoryResponse{Releases: make([]*release.Release, 0, len(history))}
+	for i := len(history) - 1; i >= 0; i-- {
+		item, err := history[i].toProto()
+		if err != nil {
+			return nil, err
+		}
+		response.Releases = append(response.Releases, item)
+	}
+	return response, nil
+}
+
+//UpdateRelease updates helm release with DefaultUpgradeOptions
+func (s *helm3ServiceImpl) UpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error) {
+	return s.updateRelease(ctx, rlsName, chartData, rawVals, defaultHelm3UpgradeOptions())
+}
+
+//DryRunUpdateRelease renders the release update without applying it
+func (s *helm3ServiceImpl) DryRunUpdateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte) (*services.UpdateReleaseResponse, error) {
+	opts := defaultHelm3UpgradeOptions()
+	opts.dryRun = true
+	return s.updateRelease(ctx, rlsName, chartData, rawVals, opts)
+}
+
+//DeployChart deploys the helm chart.
+//The result is returned on failure as well, as far as the deploy got
+func (s *helm3ServiceImpl) DeployChart(ctx context.Context, cfg domain.DeployConfig, imageTag string) (*domain.DeployResult, error) {
+	result := &domain.DeployResult{ChartVersion: cfg.ChartVersion}
+	deploy, err := prepareChartDeploy(ctx, cfg, imageTag, result, s.chartValuesService, s.chartRepositories)
+	if err != nil {
+		return result, err
+	}
+
+	logger := logging.FromContext(ctx)
+	deployed, last, err := s.releaseRevisions(cfg.ReleaseName, cfg.Namespace)
+	if err != nil {
+		logger.WithField("error", err).Warn("could not read release history")
+	}
+	result.PreviousRevision = deployed
+
+	opts, err := newHelm3UpgradeOptions(cfg, deploy)
+	if err != nil {
+		return result, err
+	}
+	response, err := s.updateRelease(ctx, cfg.ReleaseName, bytes.NewReader(deploy.chartData), deploy.rawVals, opts)
+	if err != nil {
+		if _, current, historyErr := s.releaseRevisions(cfg.ReleaseName, cfg.Namespace); historyErr == nil && current > last {
+			result.Revision = current
+		}
+		return result, err
+	}
+	result.Revision = response.GetRelease().GetVersion()
+	result.Namespace = response.GetRelease().GetNamespace()
+	return result, nil
+}
+
+//DryRunDeployChart renders the upgrade the chart deploy would make without applying it
+func (s *helm3ServiceImpl) DryRunDeployChart(ctx context.Context, cfg domain.DeployConfig) (*services.UpdateReleaseResponse, error) {
+	deploy, err := prepareChartDeploy(ctx, cfg, "", new(domain.DeployResult), s.chartValuesService, s.chartRepositories)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := newHelm3UpgradeOptions(cfg, deploy)
+	if err != nil {
+		return nil, err
+	}
+	opts.dryRun = true
+	return s.updateRelease(ctx, cfg.ReleaseName, bytes.NewReader(deploy.chartData), deploy.rawVals, opts)
+}
+
+//RollbackRelease rolls back the release to the given revision, zero means the previous one.
+//DefaultUpgradeOptions are used if options are nil
+func (s *helm3ServiceImpl) RollbackRelease(ctx context.Context, rlsName string, version int32, options *domain.UpgradeOptions) (*services.RollbackReleaseResponse, error) {
+	logger := logging.FromContext(ctx)
+	opts := domain.DefaultUpgradeOptions
+	if options != nil {
+		opts = *options
+	}
+	if err := validateHelm3UpgradeOptions(opts); err != nil {
+		return nil, errors.Wrapf(err, "could not roll back release '%s'", rlsName)
+	}
+	history, err := s.storage.history(rlsName, coreV1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &domain.ReleaseNotFoundError{Release: rlsName}
+	}
+	last := history[len(history)-1]
+	if version == 0 {
+		version = int32(last.Version) - 1
+	}
+	var target *helm3Release
+	for _, rls := range history {
+		if int32(rls.Version) == version {
+			target = rls
+		}
+	}
+	if target == nil {
+		return nil, fmt.Errorf("release '%s' has no revision %d", rlsName, version)
+	}
+
+	rls := &helm3Release{
+		Name:      rlsName,
+		Namespace: last.Namespace,
+		Version:   last.Version + 1,
+		Chart:     target.Chart,
+		Config:    target.Config,
+		Manifest:  target.Manifest,
+		Info: &helm3ReleaseInfo{
+			FirstDeployed: currentRelease(history).Info.FirstDeployed,
+			LastDeployed:  time.Now(),
+			Status:        helm3StatusPendingRollback,
+			Description:   fmt.Sprintf("Rollback to %d", version),
+		},
+	}
+	logger.WithFields(log.Fields{
+		"release":  rlsName,
+		"revision": version,
+	}).Debug("rolling back release")
+	if err := s.applyRelease(ctx, history, rls, "Rollback", rls.Info.Description, opts.Force, opts.Recreate); err != nil {
+		return nil, err
+	}
+
+	item, err := rls.toProto()
+	if err != nil {
+		return nil, err
+	}
+	return &services.RollbackReleaseResponse{Release: item}, nil
+}
+
+//DeleteRelease deletes objects of the release, its history is removed as well if purge is set
+func (s *helm3ServiceImpl) DeleteRelease(ctx context.Context, rlsName string, purge bool) (*services.UninstallReleaseResponse, error) {
+	logger := logging.FromContext(ctx)
+	history, err := s.storage.history(rlsName, coreV1.NamespaceAll)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, &domain.ReleaseNotFoundError{Release: rlsName}
+	}
+	logger.WithFields(log.Fields{
+		"release": rlsName,
+		"purge":   purge,
+	}).Debug("deleting release")
+
+	last := history[len(history)-1]
+	if last.Info.Status != helm3StatusUninstalled {
+		if err := s.resources.deleteAll(last.Namespace, last.Manifest); err != nil {
+			return nil, err
+		}
+		last.Info.Status = helm3StatusUninstalled
+		last.Info.Deleted = time.Now()
+		last.Info.Description = "Uninstallation complete"
+	}
+	if purge {
+		for _, rls := range history {
+			if err := s.storage.delete(rls); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := s.storage.update(last); err != nil {
+		return nil, err
+	}
+
+	item, err := last.toProto()
+	if err != nil {
+		return nil, err
+	}
+	return &services.UninstallReleaseResponse{Release: item}, nil
+}
+
+//RunReleaseTest is not supported, test hooks are not rendered by the Helm 3 backend
+func (s *helm3ServiceImpl) RunReleaseTest(ctx context.Context, rlsName string, timeout int64) (*release.Release, error) {
+	return nil, fmt.Errorf("could not test release '%s': release tests are not supported by the helm3 backend", rlsName)
+}
+
+//helm3UpgradeOptions controls how a release is upgraded
+type helm3UpgradeOptions struct {
+	force        bool
+	recreate     bool
+	reuseValues  bool
+	resetValues  bool
+	disableHooks bool
+	dryRun       bool
+	// namespace the release is looked up in, all namespaces are searched if empty
+	namespace string
+	// namespace the release is installed into if it does not exist, empty disables install
+	installNamespace string
+}
+
+//defaultHelm3UpgradeOptions returns DefaultUpgradeOptions the way Tiller applies them
+func defaultHelm3UpgradeOptions() helm3UpgradeOptions {
+	return helm3UpgradeOptions{
+		force:        domain.DefaultUpgradeOptions.Force,
+		recreate:     domain.DefaultUpgradeOptions.Recreate,
+		resetValues:  domain.DefaultUpgradeOptions.ResetValues,
+		reuseValues:  domain.DefaultUpgradeOptions.ReuseValues,
+		disableHooks: domain.DefaultUpgradeOptions.DisableHooks,
+	}
+}
+
+//newHelm3UpgradeOptions converts upgrade options of the deploy, DefaultUpgradeOptions are used the same way as by Tiller
+func newHelm3UpgradeOptions(cfg domain.DeployConfig, deploy *chartDeploy) (helm3UpgradeOptions, error) {
+	opts := helm3UpgradeOptions{
+		force:        deploy.options.Force,
+		recreate:     deploy.options.Recreate,
+		reuseValues:  deploy.reuseValues,
+		resetValues:  deploy.options.ResetValues,
+		disableHooks: deploy.options.DisableHooks,
+		namespace:    cfg.Namespace,
+	}
+	if err := validateHelm3UpgradeOptions(deploy.options); err != nil {
+		return opts, errors.Wrapf(err, "could not deploy release '%s'", cfg.ReleaseName)
+	}
+	if cfg.InstallIfMissing {
+		opts.installNamespace = releaseNamespace(cfg)
+	}
+	return opts, nil
+}
+
+//validateHelm3UpgradeOptions rejects upgrade options the Helm 3 backend can not honour:
+//the backend does not wait for resources
+func validateHelm3UpgradeOptions(options domain.UpgradeOptions) error {
+	var unsupported []string
+	if options.Wait {
+		unsupported = append(unsupported, "wait")
+	}
+	if options.Timeout != 0 {
+		unsupported = append(unsupported, "timeout")
+	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf("upgrade options %s are not supported by the helm3 backend", strings.Join(unsupported, ", "))
+	}
+	return nil
+}
+
+func (s *helm3ServiceImpl) updateRelease(ctx context.Context, rlsName string, chartData io.Reader, rawVals []byte, opts helm3UpgradeOptions) (*services.UpdateReleaseResponse, error) {
+	logger := logging.FromContext(ctx)
+	ch, err := chartutil.LoadArchive(chartData)
+	if err != nil {
+		return nil, err
+	}
+	logger.WithField("chart_name", ch.Metadata.Name).Debug("chart loaded")
+
+	history, err := s.storage.history(rlsName, opts.namespace)
+	if err != nil {
+		return nil, err
+	}
+	vals, err := chartutil.ReadValues(rawVals)
+	if err != nil {
+		return nil, err
+	}
+
+	rls := &helm3Release{
+		Name:   rlsName,
+		Config: vals,
+		Info:   &helm3ReleaseInfo{LastDeployed: time.Now()},
+	}
+	action, description := "Upgrade", "Upgrade complete"
+	if len(history) == 0 || history[len(history)-1].Info.Status == helm3StatusUninstalled {
+		if opts.installNamespace == "" {
+			return nil, &domain.ReleaseNotFoundError{Release: rlsName}
+		}
+		rls.Namespace = opts.installNamespace
+		rls.Version = 1
+		if len(history) > 0 {
+			rls.Version = history[len(history)-1].Version + 1
+		}
+		rls.Info.FirstDeployed = rls.Info.LastDeployed
+		rls.Info.Status = helm3StatusPendingInstall
+		rls.Info.Description = "Initial install underway"
+		action, description = "Install", "Install complete"
+	} else {
+		current := currentRelease(history)
+		if opts.reuseValues {
+			rls.Config = mergeValues(current.Config, vals)
+		} else if len(vals) == 0 && !opts.resetValues {
+			rls.Config = current.Config
+		}
+		rls.Namespace = current.Namespace
+		rls.Version = history[len(history)-1].Version + 1
+		rls.Info.FirstDeployed = current.Info.FirstDeployed
+		rls.Info.Status = helm3StatusPendingUpgrade
+		rls.Info.Description = "Preparing upgrade"
+	}
+	if err := s.render(ch, rls, opts.disableHooks, logger); err != nil {
+		return nil, err
+	}
+	if rls.Chart, err = newHelm3Chart(ch); err != nil {
+		return nil, err
+	}
+
+	if opts.dryRun {
+		rls.Info.Description = "Dry run complete"
+	} else {
+		logger.WithFields(log.Fields{
+			"release":   rlsName,
+			"namespace": rls.Namespace,
+			"revision":  rls.Version,
+		}).Debug("updating release")
+		if err := s.applyRelease(ctx, history, rls, action, description, opts.force, opts.recreate); err != nil {
+			return nil, err
+		}
+	}
+
+	item, err := rls.toProto()
+	if err != nil {
+		return nil, err
+	}
+	return &services.UpdateReleaseResponse{Release: item}, nil
+}
+
+//applyRelease stores the new revision, applies its manifest and supersedes the deployed revisions.
+//If the manifest could not be applied, the revision is recorded as failed.
+//If recreate is set, pods of upgraded workloads are deleted to be recreated by their controllers
+func (s *helm3ServiceImpl) applyRelease(ctx context.Context, history []*helm3Release, rls *helm3Release, action, description string, force, recreate bool) error {
+	logger := logging.FromContext(ctx)
+	if err := s.storage.create(rls); err != nil {
+		return err
+	}
+	var original string
+	if len(history) > 0 {
+		original = currentRelease(history).Manifest
+	}
+	if err := s.resources.update(rls.Name, rls.Namespace, original, rls.Manifest, force); err != nil {
+		rls.Info.Status = helm3StatusFailed
+		rls.Info.Description = fmt.Sprintf("%s %q failed: %s", action, rls.Name, err)
+		if err := s.storage.update(rls); err != nil {
+			logger.WithField("error", err).Error("could not record failed release")
+		}
+		return err
+	}
+	if recreate && len(history) > 0 {
+		if err := s.recreatePods(rls.Namespace, rls.Manifest); err != nil {
+			logger.WithField("error", err).Warn("could not recreate pods")
+		}
+	}
+
+	for _, previous := range history {
+		if previous.Info == nil || previous.Info.Status != helm3StatusDeployed {
+			continue
+		}
+		previous.Info.Status = helm3StatusSuperseded
+		if err := s.storage.update(previous); err != nil {
+			return err
+		}
+	}
+	rls.Info.Status = helm3StatusDeployed
+	rls.Info.Description = description
+	return s.storage.update(rls)
+}
+
+//recreatePods deletes pods selected by the workloads of the manifest
+func (s *helm3ServiceImpl) recreatePods(namespace, manifest string) error {
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		var workload struct {
+			Kind     string `json:"kind"`
+			Metadata struct {
+				Namespace string `json:"namespace"`
+			} `json:"metadata"`
+			Spec struct {
+				Selector *metaV1.LabelSelector `json:"selector"`
+			} `json:"spec"`
+		}
+		if err := yaml.Unmarshal([]byte(doc), &workload); err != nil {
+			return err
+		}
+		if !recreatedKinds[workload.Kind] || workload.Spec.Selector == nil {
+			continue
+		}
+		selector, err := metaV1.LabelSelectorAsSelector(workload.Spec.Selector)
+		if err != nil {
+			return err
+		}
+		if selector.Empty() {
+			continue
+		}
+		ns := workload.Metadata.Namespace
+		if ns == "" {
+			ns = namespace
+		}
+		err = s.client.CoreV1().Pods(ns).DeleteCollection(&metaV1.DeleteOptions{}, metaV1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			return errors.Wrapf(err, "could not delete pods of %s", workload.Kind)
+		}
+	}
+	return nil
+}
+
+//releaseRevisions returns the deployed and the latest revision of the release in the namespace
+func (s *helm3ServiceImpl) releaseRevisions(rlsName, namespace string) (deployed, last int32, err error) {
+	history, err := s.storage.history(rlsName, namespace)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, rls := range history {
+		last = int32(rls.Version)
+		if rls.Info != nil && rls.Info.Status == helm3StatusDeployed {
+			deployed = int32(rls.Version)
+		}
+	}
+	return deployed, last, nil
+}
+
+//currentRelease returns the latest deployed revision, falling back to the latest one
+func currentRelease(history []*helm3Release) *helm3Release {
+	current := history[len(history)-1]
+	for _, rls := range history {
+		if rls.Info != nil && rls.Info.Status == helm3StatusDeployed {
+			current = rls
+		}
+	}
+	return current
+}
+
+//render renders the chart with release values and sets manifest and notes of the release.
+//Chart hooks are not run by the Helm 3 backend, charts with hooks other than test hooks are rendered only if hooks are disabled.
+//Webhooks are validated on save to disable hooks, see validateDeployConfig
+func (s *helm3ServiceImpl) render(ch *chart.Chart, rls *helm3Release, disableHooks bool, logger *log.Entry) error {
+	caps, err := s.capabilities()
+	if err != nil {
+		return err
+	}
+	config, err := chartutil.Values(rls.Config).YAML()
+	if err != nil {
+		return err
+	}
+	chartConfig := &chart.Config{Raw: config}
+	if err := chartutil.ProcessRequirementsEnabled(ch, chartConfig); err != nil {
+		return err
+	}
+	if err := chartutil.ProcessRequirementsImportValues(ch); err != nil {
+		return err
+	}
+	options := chartutil.ReleaseOptions{
+		Name:      rls.Name,
+		Time:      timeconv.Timestamp(rls.Info.LastDeployed),
+		Namespace: rls.Namespace,
+		IsInstall: rls.Info.Status == helm3StatusPendingInstall,
+		IsUpgrade: rls.Info.Status != helm3StatusPendingInstall,
+		Revision:  rls.Version,
+	}
+	vals, err := chartutil.ToRenderValuesCaps(ch, chartConfig, options, caps)
+	if err != nil {
+		return err
+	}
+	files, err := engine.New().Render(ch, vals)
+	if err != nil {
+		return err
+	}
+
+	type manifest struct {
+		name, content string
+		order         int
+	}
+	manifests := make([]manifest, 0)
+	for name, content := range files {
+		if strings.HasSuffix(name, notesFileSuffix) {
+			if name == path.Join(ch.Metadata.Name, "templates", notesFileSuffix) {
+				rls.Info.Notes = content
+			}
+			continue
+		}
+		if strings.HasPrefix(path.Base(name), "_") {
+			continue
+		}
+		docs := releaseutil.SplitManifests(content)
+		for i := 0; i < len(docs); i++ {
+			doc := docs[fmt.Sprintf("manifest-%d", i)]
+			var head releaseutil.SimpleHead
+			if err := yaml.Unmarshal([]byte(doc), &head); err != nil {
+				return errors.Wrapf(err, "YAML parse error on %s", name)
+			}
+			if head.Metadata != nil {
+				if hooks, ok := head.Metadata.Annotations[hookAnnotation]; ok {
+					if !disableHooks && !isTestHook(hooks) {
+						return fmt.Errorf("template %s defines '%s' hook, chart hooks are not supported by the helm3 backend, disable hooks to deploy the chart without them", name, hooks)
+					}
+					logger.WithField("template", name).Debug("skipping chart hook")
+					continue
+				}
+			}
+			manifests = append(manifests, manifest{name: name, content: doc, order: kindOrder(head.Kind)})
+		}
+	}
+	sort.SliceStable(manifests, func(i, j int) bool {
+		if manifests[i].order != manifests[j].order {
+			return manifests[i].order < manifests[j].order
+		}
+		return manifests[i].name < manifests[j].name
+	})
+
+	var b bytes.Buffer
+	for _, m := range manifests {
+		b.WriteString("---\n# Source: " + m.name + "\n")
+		b.WriteString(m.content + "\n")
+	}
+	rls.Manifest = b.String()
+	return nil
+}
+
+func (s *helm3ServiceImpl) capabilities() (*chartutil.Capabilities, error) {
+	disc := s.client.Discovery()
+	serverVersion, err := disc.ServerVersion()
+	if err != nil {
+		return nil, err
+	}
+	groups, err := disc.ServerGroups()
+	if err != nil {
+		return nil, err
+	}
+	versions := chartutil.DefaultVersionSet
+	if groups.Size() > 0 {
+		versions = chartutil.NewVersionSet(metaV1.ExtractGroupVersions(groups)...)
+	}
+	return &chartutil.Capabilities{
+		APIVersions:   versions,
+		KubeVersion:   serverVersion,
+		TillerVersion: version.GetVersionProto(),
+	}, nil
+}
+
+//isTestHook returns true if the hook annotation lists only test hooks, they are not run on upgrade
+func isTestHook(hooks string) bool {
+	for _, hook := range strings.Split(hooks, ",") {
+		switch strings.TrimSpace(hook) {
+		case "test", "test-success", "test-failure":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func kindOrder(kind string) int {
+	for i, k := range installOrder {
+		if k == kind {
+			return i
+		}
+	}
+	return len(installOrder)
+}
+
+//mergeValues merges src into dst recursively, values of src take precedence
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	if dst == nil {
+		dst = make(map[string]interface{})
+	}
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				dst[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+	return dst
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/timeconv"
+)
+
+const (
+	helm3SecretType   = "helm.sh/release.v1"
+	helm3SecretPrefix = "sh.helm.release.v1."
+	helm3ReleaseKey   = "release"
+
+	helm3StatusDeployed        = "deployed"
+	helm3StatusSuperseded      = "superseded"
+	helm3StatusFailed          = "failed"
+	helm3StatusPendingUpgrade  = "pending-upgrade"
+	helm3StatusPendingRollback = "pending-rollback"
+	helm3StatusPendingInstall  = "pending-install"
+	helm3StatusUninstalled     = "uninstalled"
+)
+
+var helm3StatusCodes = map[string]release.Status_Code{
+	helm3StatusDeployed:        release.Status_DEPLOYED,
+	helm3StatusSuperseded:      release.Status_SUPERSEDED,
+	helm3StatusFailed:          release.Status_FAILED,
+	helm3StatusPendingUpgrade:  release.Status_PENDING_UPGRADE,
+	helm3StatusPendingRollback: release.Status_PENDING_ROLLBACK,
+	helm3StatusPendingInstall:  release.Status_PENDING_INSTALL,
+	"uninstalling":             release.Status_DELETING,
+	helm3StatusUninstalled:     release.Status_DELETED,
+}
+
+//helm3Release is the release record stored by Helm 3
+type helm3Release struct {
+	Name      string                 `json:"name,omitempty"`
+	Info      *helm3ReleaseInfo      `json:"info,omitempty"`
+	Chart     *helm3Chart            `json:"chart,omitempty"`
+	Config    map[string]interface{} `json:"config,omitempty"`
+	Manifest  string                 `json:"manifest,omitempty"`
+	Version   int                    `json:"version,omitempty"`
+	Namespace string                 `json:"namespace,omitempty"`
+}
+
+//helm3ReleaseInfo describes the state of a Helm 3 release revision
+type helm3ReleaseInfo struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+//helm3Chart is the chart stored within a Helm 3 release
+type helm3Chart struct {
+	Metadata  *helm3ChartMetadata    `json:"metadata"`
+	Templates []*helm3File           `json:"templates"`
+	Values    map[string]interface{} `json:"values"`
+	Files     []*helm3File           `json:"files"`
+}
+
+//helm3ChartMetadata is the Chart.yaml content stored within a Helm 3 release
+type helm3ChartMetadata struct {
+	Name        string              `json:"name,omitempty"`
+	Home        string              `json:"home,omitempty"`
+	Sources     []string            `json:"sources,omitempty"`
+	Version     string              `json:"version,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Keywords    []string            `json:"keywords,omitempty"`
+	Maintainers []*chart.Maintainer `json:"maintainers,omitempty"`
+	Icon        string              `json:"icon,omitempty"`
+	APIVersion  string              `json:"apiVersion,omitempty"`
+	Condition   string              `json:"condition,omitempty"`
+	Tags        string              `json:"tags,omitempty"`
+	AppVersion  string              `json:"appVersion,omitempty"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+	Annotations map[string]string   `json:"annotations,omitempty"`
+	KubeVersion string              `json:"kubeVersion,omitempty"`
+}
+
+//helm3File is a template or a plain file of a chart
+type helm3File struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+//newHelm3Chart converts a loaded chart into the Helm 3 representation
+func newHelm3Chart(ch *chart.Chart) (*helm3Chart, error) {
+	values, err := chartutil.ReadValues([]byte(ch.GetValues().GetRaw()))
+	if err != nil {
+		return nil, err
+	}
+	md := ch.GetMetadata()
+	result := &helm3Chart{
+		Metadata: &helm3ChartMetadata{
+			Name:        md.GetName(),
+			Home:        md.GetHome(),
+			Sources:     md.GetSources(),
+			Version:     md.GetVersion(),
+			Description: md.GetDescription(),
+			Keywords:    md.GetKeywords(),
+			Maintainers: md.GetMaintainers(),
+			Icon:        md.GetIcon(),
+			APIVersion:  md.GetApiVersion(),
+			Condition:   md.GetCondition(),
+			Tags:        md.GetTags(),
+			AppVersion:  md.GetAppVersion(),
+			Deprecated:  md.GetDeprecated(),
+			Annotations: md.GetAnnotations(),
+			KubeVersion: md.GetKubeVersion(),
+		},
+		Values: values,
+	}
+	for _, t := range ch.GetTemplates() {
+		result.Templates = append(result.Templates, &helm3File{Name: t.GetName(), Data: t.GetData()})
+	}
+	for _, f := range ch.GetFiles() {
+		result.Files = append(result.Files, &helm3File{Name: f.GetTypeUrl(), Data: f.GetValue()})
+	}
+	return result, nil
+}
+
+//toProto converts the release into the Tiller representation used by HelmService callers
+func (r *helm3Release) toProto() (*release.Release, error) {
+	config, err := chartutil.Values(r.Config).YAML()
+	if err != nil {
+		return nil, err
+	}
+	rls := &release.Release{
+		Name:      r.Name,
+		Namespace: r.Namespace,
+		Version:   int32(r.Version),
+		Config:    &chart.Config{Raw: config},
+		Manifest:  r.Manifest,
+		Info:      &release.Info{Status: &release.Status{Code: release.Status_UNKNOWN}},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{}},
+	}
+	if r.Info != nil {
+		rls.Info.FirstDeployed = timeconv.Timestamp(r.Info.FirstDeployed)
+		rls.Info.LastDeployed = timeconv.Timestamp(r.Info.LastDeployed)
+		rls.Info.Description = r.Info.Description
+		rls.Info.Status.Notes = r.Info.Notes
+		if code, ok := helm3StatusCodes[r.Info.Status]; ok {
+			rls.Info.Status.Code = code
+		}
+	}
+	if r.Chart != nil {
+		values, err := chartutil.Values(r.Chart.Values).YAML()
+		if err != nil {
+			return nil, err
+		}
+		rls.Chart.Values = &chart.Config{Raw: values}
+	}
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		md := r.Chart.Metadata
+		rls.Chart.Metadata = &chart.Metadata{
+			Name:        md.Name,
+			Home:        md.Home,
+			Sources:     md.Sources,
+			Version:     md.Version,
+			Description: md.Description,
+			Keywords:    md.Keywords,
+			Maintainers: md.Maintainers,
+			Icon:        md.Icon,
+			ApiVersion:  md.APIVersion,
+			Condition:   md.Condition,
+			Tags:        md.Tags,
+			AppVersion:  md.AppVersion,
+			Deprecated:  md.Deprecated,
+			Annotations: md.Annotations,
+			KubeVersion: md.KubeVersion,
+		}
+	}
+	return rls, nil
+}
+
+//helm3ReleaseStorage reads and writes Helm 3 releases stored in Kubernetes secrets
+type helm3ReleaseStorage struct {
+	client kubernetes.Interface
+}
+
+//list returns releases of the namespace matching the label selector, all namespaces are searched if namespace is empty
+func (s *helm3ReleaseStorage) list(namespace string, selector labels.Set) ([]*helm3Release, error) {
+	selector["owner"] = "helm"
+	secrets, err := s.client.CoreV1().Secrets(namespace).List(metaV1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, errors.Wrap(err, "could not list release secrets")
+	}
+	releases := make([]*helm3Release, 0, len(secrets.Items))
+	for _, secret := range secrets.Items {
+		if secret.Type != helm3SecretType {
+			continue
+		}
+		rls, err := decodeHelm3Release(secret.Data[helm3ReleaseKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not decode release secret %s/%s", secret.Namespace, secret.Name)
+		}
+		releases = append(releases, rls)
+	}
+	return releases, nil
+}
+
+//history returns all revisions of the release in the namespace sorted by version.
+//If namespace is empty, the release is looked up in all namespaces and must exist in one of them only
+func (s *helm3ReleaseStorage) history(name, namespace string) ([]*helm3Release, error) {
+	releases, err := s.list(namespace, labels.Set{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	for _, rls := range releases {
+		if rls.Namespace != releases[0].Namespace {
+			return nil, fmt.Errorf("release '%s' exists in namespaces '%s' and '%s', set the namespace of the release", name, releases[0].Namespace, rls.Namespace)
+		}
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version < releases[j].Version
+	})
+	return releases, nil
+}
+
+//create stores a new release revision
+func (s *helm3ReleaseStorage) create(rls *helm3Release) error {
+	secret, err := newHelm3Secret(rls)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CoreV1().Secrets(rls.Namespace).Create(secret)
+	return errors.Wrapf(err, "could not create secret %s", secret.Name)
+}
+
+//update replaces stored release revision
+func (s *helm3ReleaseStorage) update(rls *helm3Release) error {
+	secret, err := newHelm3Secret(rls)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.CoreV1().Secrets(rls.Namespace).Update(secret)
+	return errors.Wrapf(err, "could not update secret %s", secret.Name)
+}
+
+//delete removes stored release revision
+func (s *helm3ReleaseStorage) delete(rls *helm3Release) error {
+	name := helm3SecretName(rls)
+	err := s.client.CoreV1().Secrets(rls.Namespace).Delete(name, &metaV1.DeleteOptions{})
+	return errors.Wrapf(err, "could not delete secret %s", name)
+}
+
+func newHelm3Secret(rls *helm3Release) (*coreV1.Secret, error) {
+	data, err := encodeHelm3Release(rls)
+	if err != nil {
+		return nil, err
+	}
+	status := ""
+	if rls.Info != nil {
+		status = rls.Info.Status
+	}
+	return &coreV1.Secret{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      helm3SecretName(rls),
+			Namespace: rls.Namespace,
+			Labels: map[string]string{
+				"name":    rls.Name,
+				"owner":   "helm",
+				"status":  status,
+				"version": strconv.Itoa(rls.Version),
+			},
+		},
+		Type: helm3SecretType,
+		Data: map[string][]byte{helm3ReleaseKey: data},
+	}, nil
+}
+
+func helm3SecretName(rls *helm3Release) string {
+	return fmt.Sprintf("%s%s.v%d", helm3SecretPrefix, rls.Name, rls.Version)
+}
+
+//encodeHelm3Release encodes the release the way Helm 3 does: base64 encoded gzipped JSON
+func encodeHelm3Release(rls *helm3Release) ([]byte, error) {
+	data, err := json.Marshal(rls)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func decodeHelm3Release(data []byte) (*helm3Release, error) {
+	b, err := base64.StdEncoding.DecodeString(string(data))
+	if err != nil {
+		return nil, err
+	}
+	// releases might be stored without compression
+	if len(b) > 2 && b[0] == 0x1f && b[1] == 0x8b {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	rls := new(helm3Release)
+	if err := json.Unmarshal(b, rls); err != nil {
+		return nil, err
+	}
+	if rls.Info == nil {
+		rls.Info = new(helm3ReleaseInfo)
+	}
+	return rls, nil
+}
@@ -0,0 +1,461 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+	coreV1 "k8s.io/api/core/v1"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes"
+	typedCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+//fakeKubeClient keeps secrets in memory, records deleted pods and reports a fixed server version, other calls are not implemented
+type fakeKubeClient struct {
+	kubernetes.Interface
+	core *fakeCoreV1
+}
+
+func newFakeKubeClient() *fakeKubeClient {
+	return &fakeKubeClient{core: &fakeCoreV1{secrets: make(map[string]*coreV1.Secret)}}
+}
+
+func (c *fakeKubeClient) CoreV1() typedCoreV1.CoreV1Interface {
+	return c.core
+}
+
+func (c *fakeKubeClient) Discovery() discovery.DiscoveryInterface {
+	return fakeDiscovery{}
+}
+
+type fakeDiscovery struct {
+	discovery.DiscoveryInterface
+}
+
+func (fakeDiscovery) ServerVersion() (*version.Info, error) {
+	return &version.Info{Major: "1", Minor: "11", GitVersion: "v1.11.0"}, nil
+}
+
+func (fakeDiscovery) ServerGroups() (*metaV1.APIGroupList, error) {
+	return &metaV1.APIGroupList{}, nil
+}
+
+type fakeCoreV1 struct {
+	typedCoreV1.CoreV1Interface
+	// secrets by namespace/name
+	secrets map[string]*coreV1.Secret
+	// label selectors of deleted pods by namespace
+	deletedPods map[string][]string
+}
+
+func (c *fakeCoreV1) Secrets(namespace string) typedCoreV1.SecretInterface {
+	return &fakeSecrets{core: c, namespace: namespace}
+}
+
+func (c *fakeCoreV1) Pods(namespace string) typedCoreV1.PodInterface {
+	return &fakePods{core: c, namespace: namespace}
+}
+
+type fakePods struct {
+	typedCoreV1.PodInterface
+	core      *fakeCoreV1
+	namespace string
+}
+
+func (p *fakePods) DeleteCollection(options *metaV1.DeleteOptions, listOptions metaV1.ListOptions) error {
+	if p.core.deletedPods == nil {
+		p.core.deletedPods = make(map[string][]string)
+	}
+	p.core.deletedPods[p.namespace] = append(p.core.deletedPods[p.namespace], listOptions.LabelSelector)
+	return nil
+}
+
+type fakeSecrets struct {
+	typedCoreV1.SecretInterface
+	core      *fakeCoreV1
+	namespace string
+}
+
+func (s *fakeSecrets) notFound(name string) error {
+	return apiErrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, name)
+}
+
+func (s *fakeSecrets) Create(secret *coreV1.Secret) (*coreV1.Secret, error) {
+	key := s.namespace + "/" + secret.Name
+	if _, ok := s.core.secrets[key]; ok {
+		return nil, apiErrors.NewAlreadyExists(schema.GroupResource{Resource: "secrets"}, secret.Name)
+	}
+	s.core.secrets[key] = secret.DeepCopy()
+	return secret, nil
+}
+
+func (s *fakeSecrets) Update(secret *coreV1.Secret) (*coreV1.Secret, error) {
+	key := s.namespace + "/" + secret.Name
+	if _, ok := s.core.secrets[key]; !ok {
+		return nil, s.notFound(secret.Name)
+	}
+	s.core.secrets[key] = secret.DeepCopy()
+	return secret, nil
+}
+
+func (s *fakeSecrets) Delete(name string, options *metaV1.DeleteOptions) error {
+	key := s.namespace + "/" + name
+	if _, ok := s.core.secrets[key]; !ok {
+		return s.notFound(name)
+	}
+	delete(s.core.secrets, key)
+	return nil
+}
+
+func (s *fakeSecrets) Get(name string, options metaV1.GetOptions) (*coreV1.Secret, error) {
+	secret, ok := s.core.secrets[s.namespace+"/"+name]
+	if !ok {
+		return nil, s.notFound(name)
+	}
+	return secret.DeepCopy(), nil
+}
+
+func (s *fakeSecrets) List(opts metaV1.ListOptions) (*coreV1.SecretList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, err
+	}
+	list := new(coreV1.SecretList)
+	for _, secret := range s.core.secrets {
+		if s.namespace != coreV1.NamespaceAll && secret.Namespace != s.namespace {
+			continue
+		}
+		if selector.Matches(labels.Set(secret.Labels)) {
+			list.Items = append(list.Items, *secret.DeepCopy())
+		}
+	}
+	return list, nil
+}
+
+func TestHelm3StorageRoundTrip(t *testing.T) {
+	storage := &helm3ReleaseStorage{client: newFakeKubeClient()}
+	deployed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	first := &helm3Release{
+		Name:      "app",
+		Namespace: "apps",
+		Version:   1,
+		Config:    map[string]interface{}{"image": map[string]interface{}{"tag": "1.0"}},
+		Manifest:  "---\n# Source: app/templates/service.yaml\nkind: Service\n",
+		Chart:     &helm3Chart{Metadata: &helm3ChartMetadata{Name: "app", Version: "1.0.0"}},
+		Info:      &helm3ReleaseInfo{FirstDeployed: deployed, LastDeployed: deployed, Status: helm3StatusDeployed},
+	}
+	second := &helm3Release{Name: "app", Namespace: "apps", Version: 2, Info: &helm3ReleaseInfo{Status: helm3StatusPendingUpgrade}}
+	other := &helm3Release{Name: "api", Namespace: "apps", Version: 1, Info: &helm3ReleaseInfo{Status: helm3StatusDeployed}}
+	for _, rls := range []*helm3Release{second, other, first} {
+		if err := storage.create(rls); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second.Info.Status = helm3StatusFailed
+	if err := storage.update(second); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := storage.history("app", "apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history() returned %d revisions, want 2", len(history))
+	}
+	if !reflect.DeepEqual(history[0], first) {
+		t.Errorf("history()[0] = %+v, want %+v", history[0], first)
+	}
+	if history[1].Version != 2 || history[1].Info.Status != helm3StatusFailed {
+		t.Errorf("history()[1] = %+v, want failed revision 2", history[1])
+	}
+
+	secret, err := storage.client.CoreV1().Secrets("apps").Get("sh.helm.release.v1.app.v2", metaV1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Labels["status"] != helm3StatusFailed || secret.Type != helm3SecretType {
+		t.Errorf("secret labels = %v, type = %s", secret.Labels, secret.Type)
+	}
+
+	if err := storage.delete(second); err != nil {
+		t.Fatal(err)
+	}
+	if history, _ = storage.history("app", "apps"); len(history) != 1 {
+		t.Errorf("history() after delete returned %d revisions, want 1", len(history))
+	}
+}
+
+func TestHelm3StorageHistoryByNamespace(t *testing.T) {
+	storage := &helm3ReleaseStorage{client: newFakeKubeClient()}
+	for _, rls := range []*helm3Release{
+		{Name: "app", Namespace: "dev", Version: 1, Info: &helm3ReleaseInfo{Status: helm3StatusSuperseded}},
+		{Name: "app", Namespace: "dev", Version: 2, Info: &helm3ReleaseInfo{Status: helm3StatusDeployed}},
+		{Name: "app", Namespace: "prod", Version: 1, Info: &helm3ReleaseInfo{Status: helm3StatusDeployed}},
+	} {
+		if err := storage.create(rls); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name         string
+		namespace    string
+		wantVersions int
+		wantErr      bool
+	}{
+		{name: "dev", namespace: "dev", wantVersions: 2},
+		{name: "prod", namespace: "prod", wantVersions: 1},
+		{name: "missing", namespace: "test"},
+		{name: "ambiguous in all namespaces", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history, err := storage.history("app", tt.namespace)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("history() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(history) != tt.wantVersions {
+				t.Fatalf("history() returned %d revisions, want %d", len(history), tt.wantVersions)
+			}
+			for _, rls := range history {
+				if rls.Namespace != tt.namespace {
+					t.Errorf("history() returned revision of namespace %s, want %s", rls.Namespace, tt.namespace)
+				}
+			}
+		})
+	}
+}
+
+func TestHelm3RecreatePods(t *testing.T) {
+	client := newFakeKubeClient()
+	s := &helm3ServiceImpl{client: client}
+	manifest := `---
+kind: Service
+metadata:
+  name: app
+spec:
+  selector:
+    app: app
+---
+kind: Deployment
+metadata:
+  name: app
+spec:
+  selector:
+    matchLabels:
+      app: app
+---
+kind: StatefulSet
+metadata:
+  name: db
+  namespace: data
+spec:
+  selector:
+    matchLabels:
+      app: db
+`
+	if err := s.recreatePods("apps", manifest); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"apps": {"app=app"}, "data": {"app=db"}}
+	if !reflect.DeepEqual(client.core.deletedPods, want) {
+		t.Errorf("deleted pods = %v, want %v", client.core.deletedPods, want)
+	}
+}
+
+func newTestChart(templates map[string]string) *chart.Chart {
+	ch := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "app", Version: "1.0.0"},
+		Values:   &chart.Config{Raw: "replicas: 1\n"},
+	}
+	for name, data := range templates {
+		ch.Templates = append(ch.Templates, &chart.Template{Name: name, Data: []byte(data)})
+	}
+	return ch
+}
+
+func TestHelm3Render(t *testing.T) {
+	s := &helm3ServiceImpl{client: newFakeKubeClient()}
+	logger := log.NewEntry(log.New())
+	hook := "kind: Job\nmetadata:\n  name: migrate\n  annotations:\n    helm.sh/hook: pre-upgrade\n"
+	testHook := "kind: Pod\nmetadata:\n  name: test\n  annotations:\n    helm.sh/hook: test-success\n"
+	tests := []struct {
+		name         string
+		templates    map[string]string
+		disableHooks bool
+		wantSources  []string
+		wantErr      bool
+	}{
+		{
+			name: "manifests are sorted by kind",
+			templates: map[string]string{
+				"templates/deployment.yaml": "kind: Deployment\nmetadata:\n  name: app\nspec:\n  replicas: {{ .Values.replicas }}\n",
+				"templates/ingress.yaml":    "kind: Ingress\nmetadata:\n  name: app\n",
+				"templates/service.yaml":    "kind: Service\nmetadata:\n  name: app\n",
+				"templates/config.yaml":     "kind: ConfigMap\nmetadata:\n  name: a\n---\nkind: Secret\nmetadata:\n  name: b\n",
+				"templates/_helpers.tpl":    "{{ define \"app.name\" }}app{{ end }}",
+			},
+			wantSources: []string{"app/templates/config.yaml", "app/templates/config.yaml", "app/templates/service.yaml",
+				"app/templates/deployment.yaml", "app/templates/ingress.yaml"},
+		},
+		{
+			name:        "test hooks are skipped",
+			templates:   map[string]string{"templates/service.yaml": "kind: Service\nmetadata:\n  name: app\n", "templates/test.yaml": testHook},
+			wantSources: []string{"app/templates/service.yaml"},
+		},
+		{
+			name:      "upgrade hooks are rejected",
+			templates: map[string]string{"templates/service.yaml": "kind: Service\nmetadata:\n  name: app\n", "templates/job.yaml": hook},
+			wantErr:   true,
+		},
+		{
+			name:         "upgrade hooks are skipped if hooks are disabled",
+			templates:    map[string]string{"templates/service.yaml": "kind: Service\nmetadata:\n  name: app\n", "templates/job.yaml": hook},
+			disableHooks: true,
+			wantSources:  []string{"app/templates/service.yaml"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rls := &helm3Release{Name: "app", Namespace: "apps", Version: 1, Info: &helm3ReleaseInfo{Status: helm3StatusPendingInstall}}
+			err := s.render(newTestChart(tt.templates), rls, tt.disableHooks, logger)
+			if tt.wantErr {
+				if err == nil {
+					t.Error("render() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("render() error = %v", err)
+			}
+			var sources []string
+			for _, line := range strings.Split(rls.Manifest, "\n") {
+				if strings.HasPrefix(line, "# Source: ") {
+					sources = append(sources, strings.TrimPrefix(line, "# Source: "))
+				}
+			}
+			if !reflect.DeepEqual(sources, tt.wantSources) {
+				t.Errorf("manifest sources = %v, want %v", sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestNewHelm3UpgradeOptions(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      *domain.UpgradeOptions
+		wantForce    bool
+		wantRecreate bool
+		wantErr      bool
+	}{
+		{name: "default options as on tiller", wantForce: true, wantRecreate: true},
+		{name: "force", options: &domain.UpgradeOptions{Force: true, DisableHooks: true}, wantForce: true},
+		{name: "recreate", options: &domain.UpgradeOptions{Recreate: true}, wantRecreate: true},
+		{name: "wait", options: &domain.UpgradeOptions{Wait: true}, wantErr: true},
+		{name: "timeout", options: &domain.UpgradeOptions{Timeout: 300}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := domain.DeployConfig{ReleaseName: "app", UpgradeOptions: tt.options}
+			opts, err := newHelm3UpgradeOptions(cfg, &chartDeploy{options: cfg.GetUpgradeOptions()})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newHelm3UpgradeOptions() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && (opts.force != tt.wantForce || opts.recreate != tt.wantRecreate) {
+				t.Errorf("newHelm3UpgradeOptions() force = %v, recreate = %v, want %v, %v", opts.force, opts.recreate, tt.wantForce, tt.wantRecreate)
+			}
+		})
+	}
+}
+
+func TestHelm3InstallIfMissing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archive, err := chartutil.Save(newTestChart(map[string]string{"templates/cm.yaml": "kind: ConfigMap\nmetadata:\n  name: app\n"}), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chartData, err := ioutil.ReadFile(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		history          []*helm3Release
+		installNamespace string
+		wantVersion      int32
+		wantNamespace    string
+		wantStatus       release.Status_Code
+		wantNotFound     bool
+	}{
+		{name: "missing release is not installed", wantNotFound: true},
+		{name: "missing release is installed", installNamespace: "preview", wantVersion: 1, wantNamespace: "preview",
+			wantStatus: release.Status_PENDING_INSTALL},
+		{
+			name:         "deleted release is not installed",
+			history:      []*helm3Release{{Name: "app", Namespace: "preview", Version: 2, Info: &helm3ReleaseInfo{Status: helm3StatusUninstalled}}},
+			wantNotFound: true,
+		},
+		{
+			name:             "deleted release is installed as next revision",
+			history:          []*helm3Release{{Name: "app", Namespace: "preview", Version: 2, Info: &helm3ReleaseInfo{Status: helm3StatusUninstalled}}},
+			installNamespace: "preview",
+			wantVersion:      3,
+			wantNamespace:    "preview",
+			wantStatus:       release.Status_PENDING_INSTALL,
+		},
+		{
+			name:             "deployed release is upgraded in its namespace",
+			history:          []*helm3Release{{Name: "app", Namespace: "apps", Version: 1, Info: &helm3ReleaseInfo{Status: helm3StatusDeployed}}},
+			installNamespace: "preview",
+			wantVersion:      2,
+			wantNamespace:    "apps",
+			wantStatus:       release.Status_PENDING_UPGRADE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newFakeKubeClient()
+			s := &helm3ServiceImpl{client: client, storage: &helm3ReleaseStorage{client: client}}
+			for _, rls := range tt.history {
+				if err := s.storage.create(rls); err != nil {
+					t.Fatal(err)
+				}
+			}
+			opts := helm3UpgradeOptions{dryRun: true, installNamespace: tt.installNamespace}
+			response, err := s.updateRelease(context.Background(), "app", bytes.NewReader(chartData), nil, opts)
+			if _, ok := err.(*domain.ReleaseNotFoundError); ok != tt.wantNotFound {
+				t.Fatalf("updateRelease() error = %v, want release not found %v", err, tt.wantNotFound)
+			}
+			if tt.wantNotFound {
+				return
+			}
+			rls := response.GetRelease()
+			if rls.GetVersion() != tt.wantVersion || rls.GetNamespace() != tt.wantNamespace || rls.GetInfo().GetStatus().GetCode() != tt.wantStatus {
+				t.Errorf("updateRelease() = revision %d in %s with status %s, want revision %d in %s with status %s",
+					rls.GetVersion(), rls.GetNamespace(), rls.GetInfo().GetStatus().GetCode(), tt.wantVersion, tt.wantNamespace, tt.wantStatus)
+			}
+		})
+	}
+}
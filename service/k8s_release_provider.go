@@ -20,6 +20,11 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 )
 
+const (
+	annotationImageValuePath = "helm-deployer/image-value-path"
+	annotationAtomic         = "helm-deployer/atomic"
+)
+
 type managedRelease struct {
 	cfg    *domain.DeployConfig
 	images []string
@@ -86,17 +91,23 @@ func (s *k8sReleaseProvider) getInformers() []cache.Controller {
 	return informers
 }
 
+//GetDeployConfigsForImagePath returns deploy configs of managed releases running any tag of the image repository.
+//Path is the repository path of the image without tag, e.g. /group/app
 func (s *k8sReleaseProvider) GetDeployConfigsForImagePath(path string) ([]*domain.DeployConfig, error) {
 	s.logger.WithField("image_path", path).Debug("searching for deploy configs")
 	results := make([]*domain.DeployConfig, 0)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for name, managedRelease := range s.managedReleases {
 		for _, image := range managedRelease.images {
-			if strings.HasSuffix(image, path) {
+			if imageRepositoryMatches(image, path) {
 				s.logger.WithFields(log.Fields{
-					"name": name,
-					"path": path,
+					"name":  name,
+					"path":  path,
+					"image": image,
 				}).Debug("release found for image path")
 				results = append(results, managedRelease.cfg)
+				break
 			}
 		}
 	}
@@ -106,6 +117,19 @@ func (s *k8sReleaseProvider) GetDeployConfigsForImagePath(path string) ([]*domai
 
 	return results, nil
 }
+
+//imageRepositoryMatches returns true if the repository of the image, without its tag and digest, ends with the path
+func imageRepositoryMatches(image, path string) bool {
+	if index := strings.Index(image, "@"); index != -1 {
+		image = image[:index]
+	}
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		image = image[:index]
+	}
+	path = strings.TrimPrefix(path, "/")
+	return image == path || strings.HasSuffix(image, "/"+path)
+}
+
 func extractDeployConfig(labels map[string]string) (*domain.DeployConfig, error) {
 	var chart, release string
 	if val, ok := labels["chart"]; ok {
@@ -141,31 +165,32 @@ func extractDeployConfig(labels map[string]string) (*domain.DeployConfig, error)
 }
 
 func getClient(k8sConfigPath string, logger *log.Entry) (*kubernetes.Clientset, error) {
-	var config *rest.Config
-	var err error
-	if k8sConfigPath == "" {
-		logger.Info("using in cluster config")
-		config, err = rest.InClusterConfig()
-		// in cluster access
-	} else {
-		logger.Info("using out of cluster config")
-		config, err = clientcmd.BuildConfigFromFlags("", k8sConfigPath)
-	}
+	config, err := getRestConfig(k8sConfigPath, logger)
 	if err != nil {
 		return nil, err
 	}
 	return kubernetes.NewForConfig(config)
 }
 
+func getRestConfig(k8sConfigPath string, logger *log.Entry) (*rest.Config, error) {
+	if k8sConfigPath == "" {
+		logger.Info("using in cluster config")
+		// in cluster access
+		return rest.InClusterConfig()
+	}
+	logger.Info("using out of cluster config")
+	return clientcmd.BuildConfigFromFlags("", k8sConfigPath)
+}
+
+func isEnabled(val string) bool {
+	return val == "true" || val == "yes" || val == "1"
+}
+
 func isManagedObject(obj interface{}) bool {
 	if acc, ok := obj.(metaV1.ObjectMetaAccessor); ok {
 		meta := acc.GetObjectMeta()
 		annotations := meta.GetAnnotations()
-		if val, ok := annotations["helm-deployer/enabled"]; ok {
-			if val == "true" || val == "yes" || val == "1" {
-				return true
-			}
-		}
+		return isEnabled(annotations["helm-deployer/enabled"])
 	}
 	return false
 }
@@ -178,6 +203,8 @@ func (s *k8sReleaseProvider) extractManagedRelease(obj interface{}) *managedRele
 			s.logger.WithField("error", err).Warning("could not extract deploy config")
 			return nil
 		}
+		cfg.ImageValuePath = meta.GetAnnotations()[annotationImageValuePath]
+		cfg.Atomic = isEnabled(meta.GetAnnotations()[annotationAtomic])
 		return &managedRelease{cfg: cfg}
 	}
 	return nil
@@ -0,0 +1,28 @@
+package service
+
+import "testing"
+
+func TestImageRepositoryMatches(t *testing.T) {
+	tests := []struct {
+		image string
+		path  string
+		want  bool
+	}{
+		{image: "registry.example.com/team/app:1.0", path: "/team/app", want: true},
+		{image: "registry.example.com/team/app:1.1", path: "/team/app", want: true},
+		{image: "registry.example.com/team/app", path: "/team/app", want: true},
+		{image: "registry.example.com/team/app@sha256:0123", path: "/team/app", want: true},
+		{image: "registry.example.com:5000/team/app:1.0", path: "/team/app", want: true},
+		{image: "registry.example.com:5000/app", path: "/app", want: true},
+		{image: "team/app:1.0", path: "/team/app", want: true},
+		{image: "team/app:1.0", path: "team/app", want: true},
+		{image: "registry.example.com/team/app-worker:1.0", path: "/team/app", want: false},
+		{image: "registry.example.com/team/myapp:1.0", path: "/app", want: false},
+		{image: "registry.example.com/team/app:1.0", path: "/other/app", want: false},
+	}
+	for _, tt := range tests {
+		if got := imageRepositoryMatches(tt.image, tt.path); got != tt.want {
+			t.Errorf("imageRepositoryMatches(%s, %s) = %v, want %v", tt.image, tt.path, got, tt.want)
+		}
+	}
+}
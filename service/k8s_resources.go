@@ -0,0 +1,241 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	apiErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/helm/pkg/releaseutil"
+)
+
+const (
+	helmReleaseNameAnnotation      = "meta.helm.sh/release-name"
+	helmReleaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+//unrecreatableKinds are never deleted to force an update: they hold data, addresses or other objects
+var unrecreatableKinds = map[string]bool{
+	"Namespace":                true,
+	"CustomResourceDefinition": true,
+	"PersistentVolume":         true,
+	"PersistentVolumeClaim":    true,
+	"Service":                  true,
+	"StatefulSet":              true,
+}
+
+//kubeResource is a single object of a release manifest
+type kubeResource struct {
+	obj     *unstructured.Unstructured
+	mapping *meta.RESTMapping
+}
+
+func (r *kubeResource) key() string {
+	return fmt.Sprintf("%s/%s/%s", r.obj.GroupVersionKind().GroupKind(), r.obj.GetNamespace(), r.obj.GetName())
+}
+
+func (r *kubeResource) String() string {
+	return fmt.Sprintf("%s '%s'", r.obj.GetKind(), r.obj.GetName())
+}
+
+//kubeResourceClient creates, patches and deletes objects of release manifests
+type kubeResourceClient struct {
+	dynamicClient   dynamic.Interface
+	discoveryClient discovery.DiscoveryInterface
+}
+
+//update applies the target manifest of the release over the objects of the original manifest.
+//Objects missing in the target manifest are deleted. If force is set, objects which could not be patched are recreated
+//unless their kind is listed in unrecreatableKinds
+func (c *kubeResourceClient) update(release, namespace, originalManifest, targetManifest string, force bool) error {
+	groupResources, err := restmapper.GetAPIGroupResources(c.discoveryClient)
+	if err != nil {
+		return errors.Wrap(err, "could not discover API resources")
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+	original, err := buildKubeResources(mapper, namespace, originalManifest)
+	if err != nil {
+		return errors.Wrap(err, "could not build current resources")
+	}
+	target, err := buildKubeResources(mapper, namespace, targetManifest)
+	if err != nil {
+		return errors.Wrap(err, "could not build new resources")
+	}
+
+	originalByKey := make(map[string]*kubeResource)
+	for _, r := range original {
+		originalByKey[r.key()] = r
+	}
+	targetKeys := make(map[string]bool)
+	for _, r := range target {
+		targetKeys[r.key()] = true
+		setReleaseAnnotations(r.obj, release, namespace)
+		if err := c.apply(r, originalByKey[r.key()], release, force); err != nil {
+			return errors.Wrapf(err, "could not apply %s", r)
+		}
+	}
+	for i := len(original) - 1; i >= 0; i-- {
+		r := original[i]
+		if targetKeys[r.key()] {
+			continue
+		}
+		if err := c.delete(r); err != nil {
+			return errors.Wrapf(err, "could not delete %s", r)
+		}
+	}
+	return nil
+}
+
+//deleteAll deletes all objects of the manifest in reverse order
+func (c *kubeResourceClient) deleteAll(namespace, manifest string) error {
+	groupResources, err := restmapper.GetAPIGroupResources(c.discoveryClient)
+	if err != nil {
+		return errors.Wrap(err, "could not discover API resources")
+	}
+	resources, err := buildKubeResources(restmapper.NewDiscoveryRESTMapper(groupResources), namespace, manifest)
+	if err != nil {
+		return errors.Wrap(err, "could not build resources")
+	}
+	for i := len(resources) - 1; i >= 0; i-- {
+		if err := c.delete(resources[i]); err != nil {
+			return errors.Wrapf(err, "could not delete %s", resources[i])
+		}
+	}
+	return nil
+}
+
+//apply creates the object or patches the existing one.
+//Existing objects missing in the original manifest are patched only if they are annotated with the release
+func (c *kubeResourceClient) apply(r, original *kubeResource, release string, force bool) error {
+	client := c.resourceClient(r)
+	existing, err := client.Get(r.obj.GetName(), metaV1.GetOptions{})
+	if apiErrors.IsNotFound(err) {
+		_, err = client.Create(r.obj)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	var originalObj map[string]interface{}
+	if original != nil {
+		originalObj = original.obj.Object
+	} else if err := checkReleaseOwnership(existing, release); err != nil {
+		return err
+	}
+	patch, err := json.Marshal(createMergePatch(originalObj, r.obj.Object))
+	if err != nil {
+		return err
+	}
+	_, err = client.Patch(r.obj.GetName(), types.MergePatchType, patch)
+	if err == nil || !force {
+		return err
+	}
+	if unrecreatableKinds[r.obj.GetKind()] {
+		return errors.Wrapf(err, "%s objects are never recreated", r.obj.GetKind())
+	}
+	if err := c.delete(r); err != nil {
+		return err
+	}
+	_, err = client.Create(r.obj)
+	return err
+}
+
+func (c *kubeResourceClient) delete(r *kubeResource) error {
+	policy := metaV1.DeletePropagationBackground
+	err := c.resourceClient(r).Delete(r.obj.GetName(), &metaV1.DeleteOptions{PropagationPolicy: &policy})
+	if apiErrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (c *kubeResourceClient) resourceClient(r *kubeResource) dynamic.ResourceInterface {
+	client := c.dynamicClient.Resource(r.mapping.Resource)
+	if r.mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		return client.Namespace(r.obj.GetNamespace())
+	}
+	return client
+}
+
+//setReleaseAnnotations marks the object as managed by the release the way Helm 3 does
+func setReleaseAnnotations(obj *unstructured.Unstructured, release, namespace string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[helmReleaseNameAnnotation] = release
+	annotations[helmReleaseNamespaceAnnotation] = namespace
+	obj.SetAnnotations(annotations)
+}
+
+//checkReleaseOwnership returns an error if the existing object is not annotated with the release,
+//objects created outside of the release are never adopted
+func checkReleaseOwnership(obj *unstructured.Unstructured, release string) error {
+	if owner := obj.GetAnnotations()[helmReleaseNameAnnotation]; owner != release {
+		if owner == "" {
+			return fmt.Errorf("%s '%s' already exists and is not managed by release '%s'", obj.GetKind(), obj.GetName(), release)
+		}
+		return fmt.Errorf("%s '%s' already exists and is managed by release '%s'", obj.GetKind(), obj.GetName(), owner)
+	}
+	return nil
+}
+
+//createMergePatch creates a JSON merge patch setting all fields of the target object
+//and removing fields dropped since the original object was applied
+func createMergePatch(original, target map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{}, len(target))
+	for key, value := range target {
+		if targetMap, ok := value.(map[string]interface{}); ok {
+			if originalMap, ok := original[key].(map[string]interface{}); ok {
+				patch[key] = createMergePatch(originalMap, targetMap)
+				continue
+			}
+		}
+		patch[key] = value
+	}
+	for key := range original {
+		if _, ok := target[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	return patch
+}
+
+//buildKubeResources parses objects of the manifest, objects without namespace are put into the given namespace
+func buildKubeResources(mapper meta.RESTMapper, namespace, manifest string) ([]*kubeResource, error) {
+	docs := releaseutil.SplitManifests(manifest)
+	resources := make([]*kubeResource, 0, len(docs))
+	for i := 0; i < len(docs); i++ {
+		data, err := yaml.YAMLToJSON([]byte(docs[fmt.Sprintf("manifest-%d", i)]))
+		if err != nil {
+			return nil, err
+		}
+		if string(data) == "null" {
+			continue
+		}
+		obj := new(unstructured.Unstructured)
+		if err := obj.UnmarshalJSON(data); err != nil {
+			return nil, err
+		}
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, err
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace && obj.GetNamespace() == "" {
+			obj.SetNamespace(namespace)
+		}
+		resources = append(resources, &kubeResource{obj: obj, mapping: mapping})
+	}
+	return resources, nil
+}
@@ -0,0 +1,100 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestCreateMergePatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		original map[string]interface{}
+		target   map[string]interface{}
+		want     map[string]interface{}
+	}{
+		{
+			name:   "new object sets all fields",
+			target: map[string]interface{}{"spec": map[string]interface{}{"replicas": 2}},
+			want:   map[string]interface{}{"spec": map[string]interface{}{"replicas": 2}},
+		},
+		{
+			name:     "changed field",
+			original: map[string]interface{}{"spec": map[string]interface{}{"replicas": 1, "paused": true}},
+			target:   map[string]interface{}{"spec": map[string]interface{}{"replicas": 2, "paused": true}},
+			want:     map[string]interface{}{"spec": map[string]interface{}{"replicas": 2, "paused": true}},
+		},
+		{
+			name:     "dropped nested field is removed",
+			original: map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "a", "tier": "web"}}},
+			target:   map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "a"}}},
+			want:     map[string]interface{}{"metadata": map[string]interface{}{"labels": map[string]interface{}{"app": "a", "tier": nil}}},
+		},
+		{
+			name:     "dropped top level field is removed",
+			original: map[string]interface{}{"data": map[string]interface{}{"key": "value"}, "kind": "ConfigMap"},
+			target:   map[string]interface{}{"kind": "ConfigMap"},
+			want:     map[string]interface{}{"data": nil, "kind": "ConfigMap"},
+		},
+		{
+			name:     "lists are replaced",
+			original: map[string]interface{}{"args": []interface{}{"a", "b"}},
+			target:   map[string]interface{}{"args": []interface{}{"c"}},
+			want:     map[string]interface{}{"args": []interface{}{"c"}},
+		},
+		{
+			name:     "map replaced by value",
+			original: map[string]interface{}{"value": map[string]interface{}{"a": 1}},
+			target:   map[string]interface{}{"value": "plain"},
+			want:     map[string]interface{}{"value": "plain"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createMergePatch(tt.original, tt.target); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("createMergePatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckReleaseOwnership(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantErr     bool
+	}{
+		{name: "managed by release", annotations: map[string]string{helmReleaseNameAnnotation: "app"}},
+		{name: "not managed", wantErr: true},
+		{name: "managed by other release", annotations: map[string]string{helmReleaseNameAnnotation: "other"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := new(unstructured.Unstructured)
+			obj.SetKind("Service")
+			obj.SetName("app")
+			obj.SetAnnotations(tt.annotations)
+			if err := checkReleaseOwnership(obj, "app"); (err != nil) != tt.wantErr {
+				t.Errorf("checkReleaseOwnership() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetReleaseAnnotations(t *testing.T) {
+	obj := new(unstructured.Unstructured)
+	obj.SetAnnotations(map[string]string{"team": "web"})
+	setReleaseAnnotations(obj, "app", "apps")
+	want := map[string]string{
+		"team":                         "web",
+		helmReleaseNameAnnotation:      "app",
+		helmReleaseNamespaceAnnotation: "apps",
+	}
+	if got := obj.GetAnnotations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("annotations = %v, want %v", got, want)
+	}
+	if err := checkReleaseOwnership(obj, "app"); err != nil {
+		t.Errorf("checkReleaseOwnership() of an annotated object = %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	rolloutPollInterval = 5 * time.Second
+	podLogsLimitBytes   = 64 * 1024
+
+	deploymentReasonTimedOut     = "ProgressDeadlineExceeded"
+	deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+)
+
+//releaseLabels are the labels charts use to mark objects of a release
+var releaseLabels = []string{"release", "app.kubernetes.io/instance"}
+
+//failedContainerReasons are waiting reasons of containers which will not start without intervention
+var failedContainerReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+//WaitForRollout waits until all Deployments of the release in the namespace are rolled out and available.
+//It gives up early if a Deployment exceeded its progress deadline or pods of its latest revision can not start,
+//returned RolloutError lists the reasons found
+func (s *k8sReleaseProvider) WaitForRollout(ctx context.Context, releaseName, namespace string, timeout time.Duration) error {
+	logger := logging.FromContext(ctx).WithFields(log.Fields{
+		"release":   releaseName,
+		"namespace": namespace,
+	})
+	logger.WithField("timeout", timeout).Debug("waiting for release rollout")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var pending []appsV1.Deployment
+	var reasons []string
+	err := wait.PollImmediateUntil(rolloutPollInterval, func() (bool, error) {
+		deployments, err := s.releaseDeployments(releaseName, namespace)
+		if err != nil {
+			return false, err
+		}
+		pending, reasons = pending[:0], reasons[:0]
+		for _, d := range deployments {
+			done, reason := deploymentRolloutStatus(&d)
+			if reason != "" {
+				reasons = append(reasons, reason)
+			}
+			if done {
+				continue
+			}
+			pending = append(pending, d)
+			podReasons, err := s.deploymentPodFailures(&d)
+			if err != nil {
+				logger.WithFields(log.Fields{
+					"deployment": d.Name,
+					"error":      err,
+				}).Warn("could not inspect deployment pods")
+				continue
+			}
+			reasons = append(reasons, podReasons...)
+		}
+		// deployments which exceeded their progress deadline or pods which can not start will not recover
+		return len(pending) == 0 || len(reasons) > 0, nil
+	}, ctx.Done())
+	if err != nil && err != wait.ErrWaitTimeout {
+		return err
+	}
+	if len(pending) == 0 {
+		logger.Debug("release rolled out")
+		return nil
+	}
+	if len(reasons) == 0 {
+		for _, d := range pending {
+			reasons = append(reasons, fmt.Sprintf("deployment '%s' was not rolled out in %s", d.Name, timeout))
+		}
+	}
+	return &domain.RolloutError{Release: releaseName, Reasons: reasons}
+}
+
+//GetPodLogs returns the tail of the pod logs
+func (s *k8sReleaseProvider) GetPodLogs(namespace, name string) (string, error) {
+	limit := int64(podLogsLimitBytes)
+	data, err := s.client.CoreV1().Pods(namespace).GetLogs(name, &coreV1.PodLogOptions{LimitBytes: &limit}).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+//releaseDeployments returns Deployments in the namespace carrying any of the release labels
+func (s *k8sReleaseProvider) releaseDeployments(releaseName, namespace string) ([]appsV1.Deployment, error) {
+	seen := make(map[types.UID]bool)
+	var result []appsV1.Deployment
+	for _, label := range releaseLabels {
+		list, err := s.client.AppsV1().Deployments(namespace).List(metaV1.ListOptions{
+			LabelSelector: fmt.Sprintf("%s=%s", label, releaseName),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range list.Items {
+			if !seen[d.UID] {
+				seen[d.UID] = true
+				result = append(result, d)
+			}
+		}
+	}
+	return result, nil
+}
+
+//deploymentPodFailures returns the reasons why pods of the latest Deployment revision are not running
+func (s *k8sReleaseProvider) deploymentPodFailures(d *appsV1.Deployment) ([]string, error) {
+	selector, err := metaV1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	options := metaV1.ListOptions{LabelSelector: selector.String()}
+	replicaSets, err := s.client.AppsV1().ReplicaSets(d.Namespace).List(options)
+	if err != nil {
+		return nil, err
+	}
+	// pods of older revisions are going away, their failures do not tell anything about the rollout
+	if hash := latestPodTemplateHash(d, replicaSets.Items); hash != "" {
+		req, err := labels.NewRequirement(appsV1.DefaultDeploymentUniqueLabelKey, selection.Equals, []string{hash})
+		if err != nil {
+			return nil, err
+		}
+		options.LabelSelector = selector.Add(*req).String()
+	}
+	pods, err := s.client.CoreV1().Pods(d.Namespace).List(options)
+	if err != nil {
+		return nil, err
+	}
+	var reasons []string
+	for _, pod := range pods.Items {
+		reasons = append(reasons, podFailures(&pod)...)
+	}
+	return reasons, nil
+}
+
+//latestPodTemplateHash returns the pod template hash of the ReplicaSet of the latest Deployment revision,
+//empty if the ReplicaSet is not created yet
+func latestPodTemplateHash(d *appsV1.Deployment, replicaSets []appsV1.ReplicaSet) string {
+	revision := d.Annotations[deploymentRevisionAnnotation]
+	if revision == "" {
+		return ""
+	}
+	for _, rs := range replicaSets {
+		if !metaV1.IsControlledBy(&rs, d) || rs.Annotations[deploymentRevisionAnnotation] != revision {
+			continue
+		}
+		return rs.Labels[appsV1.DefaultDeploymentUniqueLabelKey]
+	}
+	return ""
+}
+
+//podFailures returns the reasons why the pod will not start without intervention
+func podFailures(pod *coreV1.Pod) []string {
+	var reasons []string
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == coreV1.PodScheduled && cond.Status == coreV1.ConditionFalse && cond.Reason == coreV1.PodReasonUnschedulable {
+			reasons = append(reasons, fmt.Sprintf("pod '%s' is unschedulable: %s", pod.Name, cond.Message))
+		}
+	}
+	statuses := append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if status.State.Waiting != nil && failedContainerReasons[status.State.Waiting.Reason] {
+			reasons = append(reasons, fmt.Sprintf("container '%s' of pod '%s' is in %s: %s",
+				status.Name, pod.Name, status.State.Waiting.Reason, status.State.Waiting.Message))
+		}
+	}
+	return reasons
+}
+
+//deploymentRolloutStatus returns true if the latest revision of the Deployment is available.
+//A reason is returned if the Deployment exceeded its progress deadline
+func deploymentRolloutStatus(d *appsV1.Deployment) (bool, string) {
+	if d.Generation > d.Status.ObservedGeneration {
+		return false, ""
+	}
+	for _, cond := range d.Status.Conditions {
+		if cond.Type == appsV1.DeploymentProgressing && cond.Reason == deploymentReasonTimedOut {
+			return false, fmt.Sprintf("deployment '%s' exceeded its progress deadline: %s", d.Name, cond.Message)
+		}
+	}
+	if d.Spec.Replicas != nil && d.Status.UpdatedReplicas < *d.Spec.Replicas {
+		return false, ""
+	}
+	if d.Status.Replicas > d.Status.UpdatedReplicas {
+		return false, ""
+	}
+	return d.Status.AvailableReplicas >= d.Status.UpdatedReplicas, ""
+}
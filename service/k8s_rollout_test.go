@@ -0,0 +1,73 @@
+package service
+
+import (
+	"testing"
+
+	appsV1 "k8s.io/api/apps/v1"
+	coreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestLatestPodTemplateHash(t *testing.T) {
+	d := &appsV1.Deployment{ObjectMeta: metaV1.ObjectMeta{
+		Name:        "app",
+		UID:         "deployment-uid",
+		Annotations: map[string]string{deploymentRevisionAnnotation: "2"},
+	}}
+	controller := true
+	replicaSet := func(revision, hash, owner string) appsV1.ReplicaSet {
+		return appsV1.ReplicaSet{ObjectMeta: metaV1.ObjectMeta{
+			Annotations:     map[string]string{deploymentRevisionAnnotation: revision},
+			Labels:          map[string]string{appsV1.DefaultDeploymentUniqueLabelKey: hash},
+			OwnerReferences: []metaV1.OwnerReference{{UID: "deployment-uid", Name: owner, Controller: &controller}},
+		}}
+	}
+	tests := []struct {
+		name        string
+		replicaSets []appsV1.ReplicaSet
+		want        string
+	}{
+		{name: "latest revision", replicaSets: []appsV1.ReplicaSet{replicaSet("1", "old", "app"), replicaSet("2", "new", "app")}, want: "new"},
+		{name: "latest revision not created yet", replicaSets: []appsV1.ReplicaSet{replicaSet("1", "old", "app")}},
+		{name: "replica set of other deployment", replicaSets: []appsV1.ReplicaSet{{ObjectMeta: metaV1.ObjectMeta{
+			Annotations: map[string]string{deploymentRevisionAnnotation: "2"},
+			Labels:      map[string]string{appsV1.DefaultDeploymentUniqueLabelKey: "other"},
+		}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := latestPodTemplateHash(d, tt.replicaSets); got != tt.want {
+				t.Errorf("latestPodTemplateHash() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodFailures(t *testing.T) {
+	waiting := func(reason string) coreV1.ContainerStatus {
+		return coreV1.ContainerStatus{Name: "app", State: coreV1.ContainerState{Waiting: &coreV1.ContainerStateWaiting{Reason: reason}}}
+	}
+	tests := []struct {
+		name   string
+		status coreV1.PodStatus
+		want   int
+	}{
+		{name: "running", status: coreV1.PodStatus{ContainerStatuses: []coreV1.ContainerStatus{{Name: "app"}}}},
+		{name: "creating", status: coreV1.PodStatus{ContainerStatuses: []coreV1.ContainerStatus{waiting("ContainerCreating")}}},
+		{name: "crash loop", status: coreV1.PodStatus{ContainerStatuses: []coreV1.ContainerStatus{waiting("CrashLoopBackOff")}}, want: 1},
+		{name: "init image pull", status: coreV1.PodStatus{InitContainerStatuses: []coreV1.ContainerStatus{waiting("ImagePullBackOff")}}, want: 1},
+		{name: "unschedulable", status: coreV1.PodStatus{Conditions: []coreV1.PodCondition{{
+			Type:   coreV1.PodScheduled,
+			Status: coreV1.ConditionFalse,
+			Reason: coreV1.PodReasonUnschedulable,
+		}}}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &coreV1.Pod{ObjectMeta: metaV1.ObjectMeta{Name: "app-1"}, Status: tt.status}
+			if got := podFailures(pod); len(got) != tt.want {
+				t.Errorf("podFailures() = %v, want %d reasons", got, tt.want)
+			}
+		})
+	}
+}
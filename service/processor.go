@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+//getDeployConfigs returns deploy configs of all webhooks matching the condition.
+//If a Webhook is attached to the context, only this Webhook is matched. Webhooks with their own secret
+//are verified only on their named route, so they are not matched by webhooks sent to the global route
+func getDeployConfigs(ctx context.Context, webhookService domain.WebhookService, cond domain.GitlabWebhookCondition) ([]domain.DeployConfig, error) {
+	named := domain.WebhookFromContext(ctx)
+	var webhooks []domain.Webhook
+	if named != nil {
+		webhooks = []domain.Webhook{*named}
+	} else {
+		var err error
+		webhooks, err = webhookService.FindAll()
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger := logging.FromContext(ctx)
+	var dc []domain.DeployConfig
+	for _, w := range webhooks {
+		if named == nil && w.Secret != "" {
+			continue
+		}
+		match, err := matchCondition(w.Condition, cond)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"webhook": w.Name,
+				"error":   err,
+			}).Warn("could not match webhook condition")
+			continue
+		}
+		if match {
+			dc = append(dc, w.DeployConfig)
+		}
+	}
+
+	return dc, nil
+}
+
+//filterDeployConfigs limits deploy configs found for an image to the Webhook attached to the context.
+//The Webhook's own DeployConfig is returned if it targets one of the found releases
+func filterDeployConfigs(ctx context.Context, deployConfigs []*domain.DeployConfig) []*domain.DeployConfig {
+	w := domain.WebhookFromContext(ctx)
+	if w == nil {
+		return deployConfigs
+	}
+	for _, cfg := range deployConfigs {
+		if cfg.ReleaseName == w.DeployConfig.ReleaseName {
+			return []*domain.DeployConfig{&w.DeployConfig}
+		}
+	}
+	return nil
+}
+
+//deployTrigger describes the event triggering a deploy
+type deployTrigger struct {
+	source   string
+	summary  string
+	imageTag string
+}
+
+//event returns the deploy event of the deploy config triggered by this trigger
+func (t deployTrigger) event(cfg domain.DeployConfig) domain.DeployEvent {
+	return domain.DeployEvent{
+		DeployConfig:   cfg,
+		ImageTag:       t.imageTag,
+		Trigger:        t.source,
+		TriggerSummary: t.summary,
+	}
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/entwico/helm-deployer/conf/logging"
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	headerWebhookGithub          = "X-GitHub-Event"
+	headerWebhookGithubSignature = "X-Hub-Signature-256"
+
+	githubSignaturePrefix = "sha256="
+
+	githubEventTypePing        = "ping"
+	githubEventTypePush        = "push"
+	githubEventTypeRelease     = "release"
+	githubEventTypeWorkflowRun = "workflow_run"
+
+	githubRefPrefixBranch = "refs/heads/"
+	githubRefPrefixTag    = "refs/tags/"
+
+	githubAPITimeout = 10 * time.Second
+)
+
+type githubWebhookProcessor struct {
+	webhookService domain.WebhookService
+	secret         string
+	// GitHub API looking up tags of workflow runs, lookups are disabled if empty
+	apiURL     string
+	token      string
+	httpClient *http.Client
+}
+
+//NewGithubProcessor returns new instance of GitHub webhook processor.
+//Tags of workflow runs are looked up through the GitHub API at apiURL
+func NewGithubProcessor(webhookService domain.WebhookService, secret, apiURL, token string) domain.WebhookProcessor {
+	return &githubWebhookProcessor{
+		webhookService: webhookService,
+		secret:         secret,
+		apiURL:         strings.TrimSuffix(apiURL, "/"),
+		token:          token,
+		httpClient:     &http.Client{Timeout: githubAPITimeout},
+	}
+}
+
+//Source returns the source of webhooks handled by this processor
+func (p *githubWebhookProcessor) Source() string {
+	return domain.TriggerGithub
+}
+
+//CanProcess returns true if webhook can be processed by this processor
+func (p *githubWebhookProcessor) CanProcess(ctx context.Context, headers http.Header, body []byte) bool {
+	val := headers.Get(headerWebhookGithub)
+	if val != "" {
+		return true
+	}
+	return false
+}
+
+//Verify checks X-Hub-Signature-256 header against the secret of the named Webhook or the configured secret
+func (p *githubWebhookProcessor) Verify(ctx context.Context, headers http.Header, body []byte) error {
+	secrets := getWebhookSecrets(ctx, p.secret)
+	signature := strings.TrimPrefix(headers.Get(headerWebhookGithubSignature), githubSignaturePrefix)
+	return verifyWithSecrets(secrets, func(secret string) bool {
+		return checkHMACSignature(sha256.New, secret, body, signature)
+	})
+}
+
+//Process handles webhook and returns events of the deploys it triggers
+func (p *githubWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) ([]domain.DeployEvent, error) {
+	logger := logging.FromContext(ctx)
+	logger.Info("processing GitHub webhook")
+	event := headers.Get(headerWebhookGithub)
+
+	switch event {
+	case githubEventTypePing:
+		logger.Debug("ping event received")
+		return nil, nil
+	case githubEventTypePush:
+		return p.processPushEvent(ctx, body, logger)
+	case githubEventTypeRelease:
+		return p.processReleaseEvent(ctx, body, logger)
+	case githubEventTypeWorkflowRun:
+		return p.processWorkflowRunEvent(ctx, body, logger)
+	}
+	return nil, fmt.Errorf("event '%s' not supported", event)
+}
+
+func (p *githubWebhookProcessor) processPushEvent(ctx context.Context, body []byte, logger *log.Entry) ([]domain.DeployEvent, error) {
+	logger.Debug("processing push event")
+	payload := new(WebhookGithubPush)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Deleted {
+		logger.WithField("ref", payload.Ref).Info("skipping deleted ref")
+		return nil, nil
+	}
+
+	ref, isTag := parseGithubRef(payload.Ref)
+	cond := domain.GitlabWebhookCondition{
+		WebhookType:      githubEventTypePush,
+		ProjectName:      payload.Repository.Name,
+		ProjectNamespace: payload.Repository.Owner.Login,
+		GitRef:           ref,
+		IsTag:            isTag,
+	}
+	trigger := deployTrigger{
+		source:   domain.TriggerGithub,
+		summary:  fmt.Sprintf("push to %s@%s (%s)", payload.Repository.FullName, ref, payload.After),
+		imageTag: payload.After,
+	}
+	if isTag {
+		trigger.imageTag = ref
+	}
+	return p.processCondition(ctx, cond, trigger, logger)
+}
+
+func (p *githubWebhookProcessor) processReleaseEvent(ctx context.Context, body []byte, logger *log.Entry) ([]domain.DeployEvent, error) {
+	logger.Debug("processing release event")
+	payload := new(WebhookGithubRelease)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Action != "published" || payload.Release.Draft {
+		logger.WithField("action", payload.Action).Info("skipping release action")
+		return nil, nil
+	}
+
+	cond := domain.GitlabWebhookCondition{
+		WebhookType:      githubEventTypeRelease,
+		ProjectName:      payload.Repository.Name,
+		ProjectNamespace: payload.Repository.Owner.Login,
+		GitRef:           payload.Release.TagName,
+		IsTag:            true,
+	}
+	trigger := deployTrigger{
+		source:   domain.TriggerGithub,
+		summary:  fmt.Sprintf("release %s of %s", payload.Release.TagName, payload.Repository.FullName),
+		imageTag: payload.Release.TagName,
+	}
+	return p.processCondition(ctx, cond, trigger, logger)
+}
+
+func (p *githubWebhookProcessor) processWorkflowRunEvent(ctx context.Context, body []byte, logger *log.Entry) ([]domain.DeployEvent, error) {
+	logger.Debug("processing workflow_run event")
+	payload := new(WebhookGithubWorkflowRun)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Action != "completed" || payload.WorkflowRun.Conclusion != "success" {
+		logger.WithFields(log.Fields{
+			"action":     payload.Action,
+			"conclusion": payload.WorkflowRun.Conclusion,
+		}).Info("skipping workflow run")
+		return nil, nil
+	}
+
+	ref, isTag := parseWorkflowRunRef(payload)
+	if !isTag && payload.WorkflowRun.Event == githubEventTypePush {
+		// a pushed tag is sent as the head branch, only the repository tells it apart from a branch
+		tag, err := p.isTagCommit(ctx, payload.Repository.FullName, ref, payload.WorkflowRun.HeadSha)
+		if err != nil {
+			logger.WithFields(log.Fields{
+				"ref":   ref,
+				"error": err,
+			}).Warn("could not look up tag of workflow run, treating ref as branch")
+		}
+		isTag = tag
+	}
+	cond := domain.GitlabWebhookCondition{
+		WebhookType:      githubEventTypeWorkflowRun,
+		ProjectName:      payload.Repository.Name,
+		ProjectNamespace: payload.Repository.Owner.Login,
+		GitRef:           ref,
+		IsTag:            isTag,
+	}
+	trigger := deployTrigger{
+		source: domain.TriggerGithub,
+		summary: fmt.Sprintf("workflow run '%s' of %s@%s (%s)", payload.WorkflowRun.Name, payload.Repository.FullName,
+			ref, payload.WorkflowRun.HeadSha),
+		imageTag: payload.WorkflowRun.HeadSha,
+	}
+	if isTag {
+		trigger.imageTag = ref
+	}
+	return p.processCondition(ctx, cond, trigger, logger)
+}
+
+func (p *githubWebhookProcessor) processCondition(ctx context.Context, cond domain.GitlabWebhookCondition, trigger deployTrigger, logger *log.Entry) ([]domain.DeployEvent, error) {
+	dc, err := getDeployConfigs(ctx, p.webhookService, cond)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	events := make([]domain.DeployEvent, 0, len(dc))
+	for _, cfg := range dc {
+		events = append(events, trigger.event(cfg))
+	}
+	return events, nil
+}
+
+//parseGithubRef strips refs/heads/ or refs/tags/ prefix from git ref
+func parseGithubRef(ref string) (name string, isTag bool) {
+	if strings.HasPrefix(ref, githubRefPrefixTag) {
+		return strings.TrimPrefix(ref, githubRefPrefixTag), true
+	}
+	return strings.TrimPrefix(ref, githubRefPrefixBranch), false
+}
+
+//isTagCommit returns true if the repository has the tag and the tag points to the commit
+func (p *githubWebhookProcessor) isTagCommit(ctx context.Context, repository, tag, sha string) (bool, error) {
+	if p.apiURL == "" || tag == "" || sha == "" {
+		return false, nil
+	}
+	url := fmt.Sprintf("%s/repos/%s/commits/%s%s", p.apiURL, repository, githubRefPrefixTag, tag)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/vnd.github.sha")
+	if p.token != "" {
+		req.Header.Set("Authorization", "token "+p.token)
+	}
+	resp, err := p.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusUnprocessableEntity:
+		return false, nil
+	default:
+		return false, fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == sha, nil
+}
+
+//parseWorkflowRunRef returns the ref of the workflow run. GitHub sends the short ref name in head_branch,
+//the run is on a tag if it was triggered by a release or its workflows were taken from refs/tags/.
+//Runs of pushed tags carry no such hint, see isTagCommit
+func parseWorkflowRunRef(payload *WebhookGithubWorkflowRun) (name string, isTag bool) {
+	name, isTag = parseGithubRef(payload.WorkflowRun.HeadBranch)
+	if isTag || payload.WorkflowRun.Event == githubEventTypeRelease {
+		return name, true
+	}
+	for _, w := range payload.WorkflowRun.ReferencedWorkflows {
+		if ref, tag := parseGithubRef(w.Ref); tag && ref == name {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+//WebhookGithubRepository struct
+type WebhookGithubRepository struct {
+	ID       int    `json:"id"`
+	Name     string `json:"name"`
+	FullName string `json:"full_name"`
+	Owner    struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	HTMLURL       string `json:"html_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+//WebhookGithubPush struct
+type WebhookGithubPush struct {
+	Ref        string                  `json:"ref"`
+	Before     string                  `json:"before"`
+	After      string                  `json:"after"`
+	Created    bool                    `json:"created"`
+	Deleted    bool                    `json:"deleted"`
+	Repository WebhookGithubRepository `json:"repository"`
+	Pusher     struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"pusher"`
+	HeadCommit struct {
+		ID      string `json:"id"`
+		Message string `json:"message"`
+		URL     string `json:"url"`
+	} `json:"head_commit"`
+}
+
+//WebhookGithubRelease struct
+type WebhookGithubRelease struct {
+	Action  string `json:"action"`
+	Release struct {
+		ID              int    `json:"id"`
+		TagName         string `json:"tag_name"`
+		TargetCommitish string `json:"target_commitish"`
+		Name            string `json:"name"`
+		Draft           bool   `json:"draft"`
+		Prerelease      bool   `json:"prerelease"`
+		HTMLURL         string `json:"html_url"`
+	} `json:"release"`
+	Repository WebhookGithubRepository `json:"repository"`
+}
+
+//WebhookGithubWorkflowRun struct
+type WebhookGithubWorkflowRun struct {
+	Action      string `json:"action"`
+	WorkflowRun struct {
+		ID         int    `json:"id"`
+		Name       string `json:"name"`
+		HeadBranch string `json:"head_branch"`
+		HeadSha    string `json:"head_sha"`
+		Event      string `json:"event"`
+		Status     string `json:"status"`
+		Conclusion string `json:"conclusion"`
+		HTMLURL    string `json:"html_url"`
+		// reusable workflows called by the run
+		ReferencedWorkflows []struct {
+			Path string `json:"path"`
+			Sha  string `json:"sha"`
+			Ref  string `json:"ref"`
+		} `json:"referenced_workflows"`
+	} `json:"workflow_run"`
+	Repository WebhookGithubRepository `json:"repository"`
+}
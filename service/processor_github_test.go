@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestParseWorkflowRunRef(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		ref   string
+		isTag bool
+	}{
+		{name: "branch", body: `{"workflow_run":{"head_branch":"master","event":"push"}}`, ref: "master"},
+		{name: "qualified tag", body: `{"workflow_run":{"head_branch":"refs/tags/v1.2.0","event":"push"}}`, ref: "v1.2.0", isTag: true},
+		{name: "release", body: `{"workflow_run":{"head_branch":"v1.2.0","event":"release"}}`, ref: "v1.2.0", isTag: true},
+		{name: "workflow from tag", body: `{"workflow_run":{"head_branch":"v1.2.0","event":"push",
+			"referenced_workflows":[{"path":"org/ci/.github/workflows/build.yml@v1","ref":"refs/tags/v1.2.0"}]}}`, ref: "v1.2.0", isTag: true},
+		{name: "workflow from branch", body: `{"workflow_run":{"head_branch":"master","event":"push",
+			"referenced_workflows":[{"path":"org/ci/.github/workflows/build.yml@main","ref":"refs/heads/master"}]}}`, ref: "master"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := new(WebhookGithubWorkflowRun)
+			if err := json.Unmarshal([]byte(tt.body), payload); err != nil {
+				t.Fatal(err)
+			}
+			ref, isTag := parseWorkflowRunRef(payload)
+			if ref != tt.ref || isTag != tt.isTag {
+				t.Errorf("parseWorkflowRunRef() = %s, %v, want %s, %v", ref, isTag, tt.ref, tt.isTag)
+			}
+		})
+	}
+}
+
+func TestParseGithubRef(t *testing.T) {
+	tests := []struct {
+		ref   string
+		name  string
+		isTag bool
+	}{
+		{ref: "refs/heads/master", name: "master"},
+		{ref: "refs/heads/release/1.0", name: "release/1.0"},
+		{ref: "refs/tags/v1.0.0", name: "v1.0.0", isTag: true},
+	}
+	for _, tt := range tests {
+		name, isTag := parseGithubRef(tt.ref)
+		if name != tt.name || isTag != tt.isTag {
+			t.Errorf("parseGithubRef(%s) = %s, %v, want %s, %v", tt.ref, name, isTag, tt.name, tt.isTag)
+		}
+	}
+}
+
+func TestGithubWorkflowRunOfPushedTag(t *testing.T) {
+	cond := domain.GitlabWebhookCondition{WebhookType: githubEventTypeWorkflowRun, ProjectName: "app", ProjectNamespace: "team", GitRef: "v1.2.0"}
+	tagCond := cond
+	tagCond.IsTag = true
+	webhookService := &fakeWebhookService{webhooks: []domain.Webhook{
+		{Name: "branch", Condition: cond, DeployConfig: domain.DeployConfig{ReleaseName: "branch"}},
+		{Name: "tag", Condition: tagCond, DeployConfig: domain.DeployConfig{ReleaseName: "tag"}},
+	}}
+	body := []byte(`{"action":"completed","workflow_run":{"name":"build","head_branch":"v1.2.0","head_sha":"abc123",
+		"event":"push","conclusion":"success"},"repository":{"name":"app","full_name":"team/app","owner":{"login":"team"}}}`)
+	tests := []struct {
+		name string
+		// status and body of the commit of refs/tags/v1.2.0 returned by the GitHub API
+		status       int
+		sha          string
+		wantRelease  string
+		wantImageTag string
+	}{
+		{name: "tag of head commit", status: http.StatusOK, sha: "abc123", wantRelease: "tag", wantImageTag: "v1.2.0"},
+		{name: "tag of other commit", status: http.StatusOK, sha: "def456", wantRelease: "branch", wantImageTag: "abc123"},
+		{name: "no such tag", status: http.StatusNotFound, wantRelease: "branch", wantImageTag: "abc123"},
+		{name: "api error", status: http.StatusInternalServerError, wantRelease: "branch", wantImageTag: "abc123"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/team/app/commits/refs/tags/v1.2.0" || r.Header.Get("Authorization") != "token api-token" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.sha))
+			}))
+			defer api.Close()
+			p := NewGithubProcessor(webhookService, "", api.URL, "api-token")
+			headers := make(http.Header)
+			headers.Set(headerWebhookGithub, githubEventTypeWorkflowRun)
+			events, err := p.Process(context.Background(), headers, body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 || events[0].DeployConfig.ReleaseName != tt.wantRelease || events[0].ImageTag != tt.wantImageTag {
+				t.Errorf("Process() = %+v, want deploy of %s with image tag %s", events, tt.wantRelease, tt.wantImageTag)
+			}
+		})
+	}
+}
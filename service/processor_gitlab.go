@@ -13,19 +13,28 @@ import (
 )
 
 const (
-	headerWebhookGitlab = "X-Gitlab-Event"
+	headerWebhookGitlab      = "X-Gitlab-Event"
+	headerWebhookGitlabToken = "X-Gitlab-Token"
 
 	gitlabEventTypePipeline = "Pipeline Hook"
 )
 
 type gitlabWebhookProcessor struct {
-	events         chan domain.DeployConfig
 	webhookService domain.WebhookService
+	token          string
 }
 
 //NewGitlabProcessor returns new instance of Gitlab webhook processor
-func NewGitlabProcessor(webhookService domain.WebhookService) domain.WebhookProcessor {
-	return &gitlabWebhookProcessor{webhookService: webhookService}
+func NewGitlabProcessor(webhookService domain.WebhookService, token string) domain.WebhookProcessor {
+	return &gitlabWebhookProcessor{
+		webhookService: webhookService,
+		token:          token,
+	}
+}
+
+//Source returns the source of webhooks handled by this processor
+func (p *gitlabWebhookProcessor) Source() string {
+	return domain.TriggerGitlab
 }
 
 //CanProcess returns true if webhook can be processed by this processor
@@ -37,28 +46,33 @@ func (p *gitlabWebhookProcessor) CanProcess(ctx context.Context, headers http.He
 	return false
 }
 
-//Process handles webhook
-func (p *gitlabWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) error {
+//Verify checks X-Gitlab-Token header against the secret of the named Webhook or the configured secret
+func (p *gitlabWebhookProcessor) Verify(ctx context.Context, headers http.Header, body []byte) error {
+	secrets := getWebhookSecrets(ctx, p.token)
+	token := headers.Get(headerWebhookGitlabToken)
+	return verifyWithSecrets(secrets, func(secret string) bool {
+		return checkToken(secret, token)
+	})
+}
+
+//Process handles webhook and returns events of the deploys it triggers
+func (p *gitlabWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) ([]domain.DeployEvent, error) {
 	logger := logging.FromContext(ctx)
 	logger.Info("processing Gitlab webhook")
 	event := headers.Get(headerWebhookGitlab)
 
 	switch event {
 	case gitlabEventTypePipeline:
-		return p.processPipelineEvent(body, logger)
+		return p.processPipelineEvent(ctx, body, logger)
 	}
-	return fmt.Errorf("event '%s' not supported", event)
+	return nil, fmt.Errorf("event '%s' not supported", event)
 }
 
-func (p *gitlabWebhookProcessor) GetDeployConfigEvents(ctx context.Context) chan domain.DeployConfig {
-	return p.events
-}
-
-func (p *gitlabWebhookProcessor) processPipelineEvent(body []byte, logger *log.Entry) error {
+func (p *gitlabWebhookProcessor) processPipelineEvent(ctx context.Context, body []byte, logger *log.Entry) ([]domain.DeployEvent, error) {
 	logger.Debug("processing pipeline event")
 	payload := new(WebhookGitlabPipeline)
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return err
+		return nil, err
 	}
 
 	if payload.ObjectAttributes.Status == "success" {
@@ -70,40 +84,37 @@ func (p *gitlabWebhookProcessor) processPipelineEvent(body []byte, logger *log.E
 			IsTag:            payload.ObjectAttributes.Tag,
 		}
 
-		if err := p.processCondition(cond); err != nil {
-			logger.Error(err)
-			return err
+		trigger := deployTrigger{
+			source: domain.TriggerGitlab,
+			summary: fmt.Sprintf("pipeline %d of %s@%s (%s)", payload.ObjectAttributes.ID, payload.Project.PathWithNamespace,
+				payload.ObjectAttributes.Ref, payload.ObjectAttributes.Sha),
+			imageTag: payload.ObjectAttributes.Sha,
+		}
+		if payload.ObjectAttributes.Tag {
+			trigger.imageTag = payload.ObjectAttributes.Ref
 		}
-	} else {
-		logger.WithField("status", payload.ObjectAttributes.Status).Info("skipping pipeline status")
-	}
-	return nil
-}
 
-func (p *gitlabWebhookProcessor) processCondition(cond domain.GitlabWebhookCondition) error {
-	dc, err := p.getDeployConfigs(cond)
-	if err != nil {
-		return err
-	}
-	for _, cfg := range dc {
-		p.events <- cfg
+		events, err := p.processCondition(ctx, cond, trigger)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		return events, nil
 	}
-	return nil
+	logger.WithField("status", payload.ObjectAttributes.Status).Info("skipping pipeline status")
+	return nil, nil
 }
 
-func (p *gitlabWebhookProcessor) getDeployConfigs(cond domain.GitlabWebhookCondition) ([]domain.DeployConfig, error) {
-	webhooks, err := p.webhookService.FindAll()
+func (p *gitlabWebhookProcessor) processCondition(ctx context.Context, cond domain.GitlabWebhookCondition, trigger deployTrigger) ([]domain.DeployEvent, error) {
+	dc, err := getDeployConfigs(ctx, p.webhookService, cond)
 	if err != nil {
 		return nil, err
 	}
-	var dc []domain.DeployConfig
-	for _, w := range webhooks {
-		if w.Condition == cond {
-			dc = append(dc, w.DeployConfig)
-		}
+	events := make([]domain.DeployEvent, 0, len(dc))
+	for _, cfg := range dc {
+		events = append(events, trigger.event(cfg))
 	}
-
-	return dc, nil
+	return events, nil
 }
 
 //WebhookGitlabPipeline struct
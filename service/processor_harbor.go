@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	headerWebhookHarborAuth = "Authorization"
+
+	harborEventTypePushArtifact = "PUSH_ARTIFACT"
+)
+
+type harborWebhookProcessor struct {
+	releaseProvider domain.K8SReleaseProvider
+	authHeader      string
+	logger          *log.Entry
+}
+
+//NewHarborProcessor returns new instance of Harbor webhook processor.
+//If authHeader is not empty, only webhooks sending the same Authorization header are accepted
+func NewHarborProcessor(releaseProvider domain.K8SReleaseProvider, authHeader string, logger *log.Entry) domain.WebhookProcessor {
+	return &harborWebhookProcessor{
+		releaseProvider: releaseProvider,
+		authHeader:      authHeader,
+		logger:          logger,
+	}
+}
+
+//Source returns the source of webhooks handled by this processor
+func (p *harborWebhookProcessor) Source() string {
+	return domain.TriggerHarbor
+}
+
+//CanProcess returns true if webhook can be processed by this processor
+func (p *harborWebhookProcessor) CanProcess(ctx context.Context, headers http.Header, body []byte) bool {
+	payload := new(WebhookHarborEvent)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return false
+	}
+	return payload.Type != "" && payload.EventData.Repository.RepoFullName != ""
+}
+
+//Verify checks Authorization header against the secret of the named Webhook or the configured Harbor auth header
+func (p *harborWebhookProcessor) Verify(ctx context.Context, headers http.Header, body []byte) error {
+	secrets := getWebhookSecrets(ctx, p.authHeader)
+	authHeader := headers.Get(headerWebhookHarborAuth)
+	return verifyWithSecrets(secrets, func(secret string) bool {
+		return checkToken(secret, authHeader)
+	})
+}
+
+//Process handles webhook and returns events of the deploys it triggers
+func (p *harborWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) ([]domain.DeployEvent, error) {
+	p.logger.Info("processing Harbor webhook")
+	payload := new(WebhookHarborEvent)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	switch payload.Type {
+	case harborEventTypePushArtifact:
+		return p.processPushArtifactEvent(ctx, payload)
+	default:
+		p.logger.WithField("event", payload.Type).Debug("skipping event")
+	}
+	return nil, nil
+}
+
+func (p *harborWebhookProcessor) processPushArtifactEvent(ctx context.Context, payload *WebhookHarborEvent) ([]domain.DeployEvent, error) {
+	p.logger.Debug("processing push artifact event")
+	repository := payload.EventData.Repository.RepoFullName
+	var events []domain.DeployEvent
+	for _, resource := range payload.EventData.Resources {
+		tag := resource.GetTag()
+		if tag == "" {
+			p.logger.WithField("resource_url", resource.ResourceURL).Debug("ignoring untagged artifact")
+			continue
+		}
+
+		imagePath := fmt.Sprintf("/%s:%s", repository, tag)
+		p.logger.WithFields(log.Fields{
+			"image":        imagePath,
+			"resource_url": resource.ResourceURL,
+		}).Debug("artifact pushed to Harbor")
+		deployConfigs, err := p.releaseProvider.GetDeployConfigsForImagePath("/" + repository)
+		if err != nil {
+			return nil, err
+		}
+		trigger := deployTrigger{
+			source:   domain.TriggerHarbor,
+			summary:  fmt.Sprintf("artifact %s pushed by %s", resource.ResourceURL, payload.Operator),
+			imageTag: tag,
+		}
+		for _, cfg := range filterDeployConfigs(ctx, deployConfigs) {
+			events = append(events, trigger.event(*cfg))
+		}
+	}
+
+	return events, nil
+}
+
+//WebhookHarborEvent defines Harbor webhook payload structure
+type WebhookHarborEvent struct {
+	Type      string `json:"type"`
+	OccurAt   int64  `json:"occur_at"`
+	Operator  string `json:"operator"`
+	EventData struct {
+		Resources  []WebhookHarborResource `json:"resources"`
+		Repository struct {
+			DateCreated  int64  `json:"date_created"`
+			Name         string `json:"name"`
+			Namespace    string `json:"namespace"`
+			RepoFullName string `json:"repo_full_name"`
+			RepoType     string `json:"repo_type"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+//WebhookHarborResource defines a pushed Harbor artifact
+type WebhookHarborResource struct {
+	Digest      string `json:"digest"`
+	Tag         string `json:"tag"`
+	ResourceURL string `json:"resource_url"`
+}
+
+//GetTag returns artifact tag, falling back to the tag part of the resource url.
+//Artifacts referenced by digest have no tag
+func (r WebhookHarborResource) GetTag() string {
+	if r.Tag != "" {
+		return r.Tag
+	}
+	if strings.Contains(r.ResourceURL, "@") {
+		return ""
+	}
+	index := strings.LastIndex(r.ResourceURL, ":")
+	if index == -1 || index < strings.LastIndex(r.ResourceURL, "/") {
+		return ""
+	}
+	return r.ResourceURL[index+1:]
+}
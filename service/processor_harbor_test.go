@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+func TestWebhookHarborResourceGetTag(t *testing.T) {
+	tests := []struct {
+		resource WebhookHarborResource
+		want     string
+	}{
+		{resource: WebhookHarborResource{Tag: "1.0", ResourceURL: "harbor.example.com/team/app:0.9"}, want: "1.0"},
+		{resource: WebhookHarborResource{ResourceURL: "harbor.example.com/team/app:1.1"}, want: "1.1"},
+		{resource: WebhookHarborResource{ResourceURL: "harbor.example.com:8443/team/app:1.2"}, want: "1.2"},
+		{resource: WebhookHarborResource{ResourceURL: "harbor.example.com:8443/team/app"}},
+		{resource: WebhookHarborResource{ResourceURL: "harbor.example.com/team/app@sha256:0123"}},
+		{resource: WebhookHarborResource{}},
+	}
+	for _, tt := range tests {
+		if got := tt.resource.GetTag(); got != tt.want {
+			t.Errorf("GetTag() of %+v = %q, want %q", tt.resource, got, tt.want)
+		}
+	}
+}
+
+func TestHarborProcessorCanProcess(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want bool
+	}{
+		{name: "push artifact", body: `{"type":"PUSH_ARTIFACT","event_data":{"repository":{"repo_full_name":"team/app"}}}`, want: true},
+		{name: "other event", body: `{"type":"DELETE_ARTIFACT","event_data":{"repository":{"repo_full_name":"team/app"}}}`, want: true},
+		{name: "gitlab pipeline", body: `{"object_kind":"pipeline","project":{"name":"app"}}`},
+		{name: "invalid json", body: `{`},
+	}
+	p := NewHarborProcessor(nil, "", log.NewEntry(log.New()))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.CanProcess(context.Background(), nil, []byte(tt.body)); got != tt.want {
+				t.Errorf("CanProcess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHarborProcessorProcess(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		wantTags  []string
+		wantPaths []string
+	}{
+		{
+			name: "pushed artifacts",
+			body: `{"type":"PUSH_ARTIFACT","operator":"ci","event_data":{"repository":{"repo_full_name":"team/app"},
+				"resources":[{"tag":"1.0","resource_url":"harbor.example.com/team/app:1.0"},
+				{"resource_url":"harbor.example.com/team/app:1.1"}]}}`,
+			wantTags:  []string{"1.0", "1.1"},
+			wantPaths: []string{"/team/app", "/team/app"},
+		},
+		{
+			name: "untagged artifact",
+			body: `{"type":"PUSH_ARTIFACT","event_data":{"repository":{"repo_full_name":"team/app"},
+				"resources":[{"digest":"sha256:0123","resource_url":"harbor.example.com/team/app"}]}}`,
+		},
+		{
+			name: "other event",
+			body: `{"type":"DELETE_ARTIFACT","event_data":{"repository":{"repo_full_name":"team/app"},
+				"resources":[{"tag":"1.0","resource_url":"harbor.example.com/team/app:1.0"}]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeReleaseProvider{cfg: domain.DeployConfig{ReleaseName: "app"}}
+			p := NewHarborProcessor(provider, "", log.NewEntry(log.New()))
+			events, err := p.Process(context.Background(), nil, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(tt.wantTags) {
+				t.Fatalf("Process() returned %d events, want %d", len(events), len(tt.wantTags))
+			}
+			for i, event := range events {
+				if event.ImageTag != tt.wantTags[i] || event.Trigger != domain.TriggerHarbor {
+					t.Errorf("event %d = %+v, want Harbor event of tag %s", i, event, tt.wantTags[i])
+				}
+			}
+			if len(provider.paths) != len(tt.wantPaths) {
+				t.Errorf("GetDeployConfigsForImagePath() called with %v, want %v", provider.paths, tt.wantPaths)
+			}
+		})
+	}
+}
+
+func TestHarborProcessorVerify(t *testing.T) {
+	named := &domain.Webhook{Name: "app", Secret: "webhook-secret"}
+	tests := []struct {
+		name       string
+		authHeader string
+		webhook    *domain.Webhook
+		token      string
+		want       error
+	}{
+		{name: "global route", authHeader: "harbor-secret", token: "harbor-secret"},
+		{name: "global route wrong token", authHeader: "harbor-secret", token: "other", want: ErrInvalidSignature},
+		{name: "global route without auth header", token: "harbor-secret", want: ErrNoWebhookSecret},
+		{name: "named route", authHeader: "harbor-secret", webhook: named, token: "webhook-secret"},
+		{name: "named route missing token", authHeader: "harbor-secret", webhook: named, want: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewHarborProcessor(nil, tt.authHeader, log.NewEntry(log.New()))
+			ctx := context.Background()
+			if tt.webhook != nil {
+				ctx = domain.NewContextWithWebhook(ctx, tt.webhook)
+			}
+			headers := make(http.Header)
+			if tt.token != "" {
+				headers.Set(headerWebhookHarborAuth, tt.token)
+			}
+			if err := p.Verify(ctx, headers, nil); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -14,24 +15,30 @@ import (
 const (
 	headerWebhookNexus     = "X-Nexus-Webhook-Delivery"
 	headerWebhookNexusType = "X-Nexus-Webhook-Id"
+	headerWebhookNexusSign = "X-Nexus-Webhook-Signature"
 	nexusEventTypeAsset    = "rm:repository:asset"
 )
 
 type nexusWebhookProcessor struct {
 	releaseProvider domain.K8SReleaseProvider
-	events          chan domain.DeployConfig
+	secret          string
 	logger          *log.Entry
 }
 
 //NewNexusProcessor returns new instance of Nexus webhook processor
-func NewNexusProcessor(releaseProvider domain.K8SReleaseProvider, logger *log.Entry) domain.WebhookProcessor {
+func NewNexusProcessor(releaseProvider domain.K8SReleaseProvider, secret string, logger *log.Entry) domain.WebhookProcessor {
 	return &nexusWebhookProcessor{
 		releaseProvider: releaseProvider,
-		events:          make(chan domain.DeployConfig),
+		secret:          secret,
 		logger:          logger,
 	}
 }
 
+//Source returns the source of webhooks handled by this processor
+func (p *nexusWebhookProcessor) Source() string {
+	return domain.TriggerNexus
+}
+
 //CanProcess returns true if webhook can be processed by this processor
 func (p *nexusWebhookProcessor) CanProcess(ctx context.Context, headers http.Header, body []byte) bool {
 	val := headers.Get(headerWebhookNexus)
@@ -41,36 +48,41 @@ func (p *nexusWebhookProcessor) CanProcess(ctx context.Context, headers http.Hea
 	return false
 }
 
-//Process handles webhook
-func (p *nexusWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) error {
+//Verify checks X-Nexus-Webhook-Signature header (HMAC-SHA1 of the body) against the secret of the named Webhook or the configured secret
+func (p *nexusWebhookProcessor) Verify(ctx context.Context, headers http.Header, body []byte) error {
+	secrets := getWebhookSecrets(ctx, p.secret)
+	signature := headers.Get(headerWebhookNexusSign)
+	return verifyWithSecrets(secrets, func(secret string) bool {
+		return checkHMACSignature(sha1.New, secret, body, signature)
+	})
+}
+
+//Process handles webhook and returns events of the deploys it triggers
+func (p *nexusWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) ([]domain.DeployEvent, error) {
 	p.logger.Info("processing Nexus webhook")
 	event := headers.Get(headerWebhookNexusType)
 
 	switch event {
 	case nexusEventTypeAsset:
-		return p.processAssetEvent(body)
+		return p.processAssetEvent(ctx, body)
 	default:
 		p.logger.WithField("event", event).Debug("skipping event")
 	}
-	return nil
+	return nil, nil
 }
 
-func (p *nexusWebhookProcessor) GetDeployConfigEvents(ctx context.Context) chan domain.DeployConfig {
-	return p.events
-}
-
-func (p *nexusWebhookProcessor) processAssetEvent(body []byte) error {
+func (p *nexusWebhookProcessor) processAssetEvent(ctx context.Context, body []byte) ([]domain.DeployEvent, error) {
 	p.logger.Debug("processing asset event")
 	payload := new(WebhookNexusAsset)
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return err
+		return nil, err
 	}
 	if payload.Asset.Format != "docker" || !(payload.Action == "UPDATED" || payload.Action == "CREATED") {
 		p.logger.WithFields(log.Fields{
 			"action": payload.Action,
 			"format": payload.Asset.Format,
 		}).Debug("ignoring asset event")
-		return nil
+		return nil, nil
 	}
 
 	path, tag := payload.GetRepositoryPathAndTag()
@@ -80,16 +92,23 @@ func (p *nexusWebhookProcessor) processAssetEvent(body []byte) error {
 			"image":      imagePath,
 			"repository": payload.RepositoryName,
 		}).Debug("image updated in repository")
-		deployConfigs, err := p.releaseProvider.GetDeployConfigsForImagePath(imagePath)
+		deployConfigs, err := p.releaseProvider.GetDeployConfigsForImagePath(path)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		trigger := deployTrigger{
+			source:   domain.TriggerNexus,
+			summary:  fmt.Sprintf("image %s %s in repository %s", imagePath, strings.ToLower(payload.Action), payload.RepositoryName),
+			imageTag: tag,
 		}
-		for _, cfg := range deployConfigs {
-			p.events <- *cfg
+		var events []domain.DeployEvent
+		for _, cfg := range filterDeployConfigs(ctx, deployConfigs) {
+			events = append(events, trigger.event(*cfg))
 		}
+		return events, nil
 	}
 
-	return nil
+	return nil, nil
 }
 
 //WebhookNexusAsset defines webhook payload structure
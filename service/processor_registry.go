@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	headerContentType         = "Content-Type"
+	headerWebhookRegistryAuth = "Authorization"
+	registryEventsMediaType   = "application/vnd.docker.distribution.events.v1+json"
+	registryEventActionPush   = "push"
+)
+
+type registryWebhookProcessor struct {
+	releaseProvider domain.K8SReleaseProvider
+	authHeader      string
+	logger          *log.Entry
+}
+
+//NewRegistryProcessor returns new instance of Docker Registry notification processor.
+//Notifications sent to the global route are accepted only if they carry authHeader as Authorization header
+func NewRegistryProcessor(releaseProvider domain.K8SReleaseProvider, authHeader string, logger *log.Entry) domain.WebhookProcessor {
+	return &registryWebhookProcessor{
+		releaseProvider: releaseProvider,
+		authHeader:      authHeader,
+		logger:          logger,
+	}
+}
+
+//Source returns the source of webhooks handled by this processor
+func (p *registryWebhookProcessor) Source() string {
+	return domain.TriggerRegistry
+}
+
+//CanProcess returns true if webhook can be processed by this processor
+func (p *registryWebhookProcessor) CanProcess(ctx context.Context, headers http.Header, body []byte) bool {
+	mediaType, _, err := mime.ParseMediaType(headers.Get(headerContentType))
+	if err != nil {
+		return false
+	}
+	return mediaType == registryEventsMediaType
+}
+
+//Verify checks Authorization header against the secret of the named Webhook or the configured registry auth header.
+//Docker Registry notifications are not signed, so the header has to be configured in the registry endpoint
+func (p *registryWebhookProcessor) Verify(ctx context.Context, headers http.Header, body []byte) error {
+	secrets := getWebhookSecrets(ctx, p.authHeader)
+	authHeader := headers.Get(headerWebhookRegistryAuth)
+	return verifyWithSecrets(secrets, func(secret string) bool {
+		return checkToken(secret, authHeader)
+	})
+}
+
+//Process handles webhook and returns events of the deploys it triggers
+func (p *registryWebhookProcessor) Process(ctx context.Context, headers http.Header, body []byte) ([]domain.DeployEvent, error) {
+	p.logger.Info("processing Docker Registry notification")
+	payload := new(WebhookRegistryEnvelope)
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+
+	var events []domain.DeployEvent
+	for _, event := range payload.Events {
+		switch event.Action {
+		case registryEventActionPush:
+			pushEvents, err := p.processPushEvent(ctx, event)
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, pushEvents...)
+		default:
+			p.logger.WithField("action", event.Action).Debug("skipping event")
+		}
+	}
+	return events, nil
+}
+
+func (p *registryWebhookProcessor) processPushEvent(ctx context.Context, event WebhookRegistryEvent) ([]domain.DeployEvent, error) {
+	p.logger.Debug("processing push event")
+	if event.Target.Tag == "" {
+		p.logger.WithFields(log.Fields{
+			"repository": event.Target.Repository,
+			"digest":     event.Target.Digest,
+		}).Debug("ignoring untagged push event")
+		return nil, nil
+	}
+
+	imagePath := fmt.Sprintf("/%s:%s", event.Target.Repository, event.Target.Tag)
+	p.logger.WithFields(log.Fields{
+		"image":    imagePath,
+		"registry": event.Request.Host,
+	}).Debug("image pushed to registry")
+	deployConfigs, err := p.releaseProvider.GetDeployConfigsForImagePath("/" + event.Target.Repository)
+	if err != nil {
+		return nil, err
+	}
+	trigger := deployTrigger{
+		source:   domain.TriggerRegistry,
+		summary:  fmt.Sprintf("image %s pushed to %s", imagePath, event.Request.Host),
+		imageTag: event.Target.Tag,
+	}
+	var events []domain.DeployEvent
+	for _, cfg := range filterDeployConfigs(ctx, deployConfigs) {
+		events = append(events, trigger.event(*cfg))
+	}
+
+	return events, nil
+}
+
+//WebhookRegistryEnvelope defines Docker Registry notification payload structure
+type WebhookRegistryEnvelope struct {
+	Events []WebhookRegistryEvent `json:"events"`
+}
+
+//WebhookRegistryEvent defines a single Docker Registry notification event
+type WebhookRegistryEvent struct {
+	ID        string `json:"id"`
+	Timestamp string `json:"timestamp"`
+	Action    string `json:"action"`
+	Target    struct {
+		MediaType  string `json:"mediaType"`
+		Size       int64  `json:"size"`
+		Digest     string `json:"digest"`
+		Length     int64  `json:"length"`
+		Repository string `json:"repository"`
+		URL        string `json:"url"`
+		Tag        string `json:"tag"`
+	} `json:"target"`
+	Request struct {
+		ID        string `json:"id"`
+		Addr      string `json:"addr"`
+		Host      string `json:"host"`
+		Method    string `json:"method"`
+		UserAgent string `json:"useragent"`
+	} `json:"request"`
+	Actor struct {
+		Name string `json:"name"`
+	} `json:"actor"`
+	Source struct {
+		Addr       string `json:"addr"`
+		InstanceID string `json:"instanceID"`
+	} `json:"source"`
+}
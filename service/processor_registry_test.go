@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	log "github.com/sirupsen/logrus"
+)
+
+//fakeReleaseProvider returns the deploy config for every image path and records requested paths
+type fakeReleaseProvider struct {
+	domain.K8SReleaseProvider
+	cfg   domain.DeployConfig
+	paths []string
+}
+
+func (p *fakeReleaseProvider) GetDeployConfigsForImagePath(path string) ([]*domain.DeployConfig, error) {
+	p.paths = append(p.paths, path)
+	cfg := p.cfg
+	return []*domain.DeployConfig{&cfg}, nil
+}
+
+func TestRegistryProcessorInjectsPushedTag(t *testing.T) {
+	provider := &fakeReleaseProvider{cfg: domain.DeployConfig{ReleaseName: "app", ImageValuePath: "image.tag"}}
+	p := NewRegistryProcessor(provider, "", log.NewEntry(log.New()))
+	body := []byte(`{"events":[{"action":"push","target":{"repository":"team/app","tag":"1.1"},"request":{"host":"registry"}}]}`)
+
+	events, err := p.Process(context.Background(), nil, body)
+	if err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Process() returned %d events, want 1", len(events))
+	}
+	if event := events[0]; event.ImageTag != "1.1" || event.Trigger != domain.TriggerRegistry || event.DeployConfig.ReleaseName != "app" {
+		t.Errorf("unexpected deploy event %+v", event)
+	}
+	if len(provider.paths) != 1 || provider.paths[0] != "/team/app" {
+		t.Errorf("GetDeployConfigsForImagePath() called with %v, want [/team/app]", provider.paths)
+	}
+}
+
+func TestRegistryProcessorVerify(t *testing.T) {
+	named := &domain.Webhook{Name: "app", Secret: "Bearer webhook-token"}
+	tests := []struct {
+		name       string
+		authHeader string
+		webhook    *domain.Webhook
+		token      string
+		want       error
+	}{
+		{name: "global route", authHeader: "Bearer registry-token", token: "Bearer registry-token"},
+		{name: "global route wrong token", authHeader: "Bearer registry-token", token: "Bearer other", want: ErrInvalidSignature},
+		{name: "global route missing token", authHeader: "Bearer registry-token", want: ErrInvalidSignature},
+		{name: "global route without auth header", token: "Bearer registry-token", want: ErrNoWebhookSecret},
+		{name: "named route", authHeader: "Bearer registry-token", webhook: named, token: "Bearer webhook-token"},
+		{name: "named route does not accept global token", authHeader: "Bearer registry-token", webhook: named,
+			token: "Bearer registry-token", want: ErrInvalidSignature},
+		{name: "named route missing token", webhook: named, want: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewRegistryProcessor(nil, tt.authHeader, log.NewEntry(log.New()))
+			ctx := context.Background()
+			if tt.webhook != nil {
+				ctx = domain.NewContextWithWebhook(ctx, tt.webhook)
+			}
+			headers := make(http.Header)
+			if tt.token != "" {
+				headers.Set(headerWebhookRegistryAuth, tt.token)
+			}
+			if err := p.Verify(ctx, headers, nil); err != tt.want {
+				t.Errorf("Verify() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryProcessorCanProcess(t *testing.T) {
+	tests := []struct {
+		contentType string
+		want        bool
+	}{
+		{contentType: registryEventsMediaType, want: true},
+		{contentType: registryEventsMediaType + "; charset=utf-8", want: true},
+		{contentType: "application/json"},
+		{},
+	}
+	p := NewRegistryProcessor(nil, "", log.NewEntry(log.New()))
+	for _, tt := range tests {
+		headers := make(http.Header)
+		headers.Set(headerContentType, tt.contentType)
+		if got := p.CanProcess(context.Background(), headers, nil); got != tt.want {
+			t.Errorf("CanProcess(%s) = %v, want %v", tt.contentType, got, tt.want)
+		}
+	}
+}
+
+func TestRegistryProcessorProcess(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantTags []string
+	}{
+		{name: "pushed tags", body: `{"events":[{"action":"push","target":{"repository":"team/app","tag":"1.0"}},
+			{"action":"push","target":{"repository":"team/app","tag":"1.1"}}]}`, wantTags: []string{"1.0", "1.1"}},
+		{name: "untagged push", body: `{"events":[{"action":"push","target":{"repository":"team/app","digest":"sha256:0123"}}]}`},
+		{name: "pull", body: `{"events":[{"action":"pull","target":{"repository":"team/app","tag":"1.0"}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeReleaseProvider{cfg: domain.DeployConfig{ReleaseName: "app"}}
+			p := NewRegistryProcessor(provider, "", log.NewEntry(log.New()))
+			events, err := p.Process(context.Background(), nil, []byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != len(tt.wantTags) {
+				t.Fatalf("Process() returned %d events, want %d", len(events), len(tt.wantTags))
+			}
+			for i, event := range events {
+				if event.ImageTag != tt.wantTags[i] {
+					t.Errorf("event %d image tag = %s, want %s", i, event.ImageTag, tt.wantTags[i])
+				}
+			}
+		})
+	}
+	if _, err := NewRegistryProcessor(nil, "", log.NewEntry(log.New())).Process(context.Background(), nil, []byte("{")); err == nil {
+		t.Error("Process() of an invalid payload succeeded, want error")
+	}
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+//fakeWebhookService returns the stored webhooks
+type fakeWebhookService struct {
+	domain.WebhookService
+	webhooks []domain.Webhook
+}
+
+func (s *fakeWebhookService) FindAll() ([]domain.Webhook, error) {
+	return s.webhooks, nil
+}
+
+func TestGetDeployConfigs(t *testing.T) {
+	cond := domain.GitlabWebhookCondition{WebhookType: "pipeline", ProjectName: "app", ProjectNamespace: "team", GitRef: "master"}
+	shared := domain.Webhook{Name: "shared", Condition: cond, DeployConfig: domain.DeployConfig{ReleaseName: "shared"}}
+	teamA := domain.Webhook{Name: "team-a", Secret: "a", Condition: cond, DeployConfig: domain.DeployConfig{ReleaseName: "team-a"}}
+	teamB := domain.Webhook{Name: "team-b", Secret: "b", Condition: cond, DeployConfig: domain.DeployConfig{ReleaseName: "team-b"}}
+	webhookService := &fakeWebhookService{webhooks: []domain.Webhook{shared, teamA, teamB}}
+
+	tests := []struct {
+		name    string
+		webhook *domain.Webhook
+		want    []string
+	}{
+		{name: "global route skips webhooks with secret", want: []string{"shared"}},
+		{name: "named route matches only its webhook", webhook: &teamA, want: []string{"team-a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.webhook != nil {
+				ctx = domain.NewContextWithWebhook(ctx, tt.webhook)
+			}
+			dc, err := getDeployConfigs(ctx, webhookService, cond)
+			if err != nil {
+				t.Fatalf("getDeployConfigs() error = %v", err)
+			}
+			var got []string
+			for _, cfg := range dc {
+				got = append(got, cfg.ReleaseName)
+			}
+			if len(got) != len(tt.want) || (len(got) > 0 && got[0] != tt.want[0]) {
+				t.Errorf("getDeployConfigs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,16 +8,21 @@ import (
 	"github.com/entwico/helm-deployer/conf/logging"
 	"github.com/entwico/helm-deployer/domain"
 	"github.com/golang/protobuf/ptypes"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/release"
 )
 
 type releaseServiceImpl struct {
-	HelmService domain.HelmService
+	HelmService    domain.HelmService
+	WebhookService domain.WebhookService
 }
 
 //NewReleaseService returns a new instance of ReleaseService
-func NewReleaseService(helmService domain.HelmService) domain.ReleaseService {
+func NewReleaseService(helmService domain.HelmService, webhookService domain.WebhookService) domain.ReleaseService {
 	return &releaseServiceImpl{
-		HelmService: helmService,
+		HelmService:    helmService,
+		WebhookService: webhookService,
 	}
 }
 
@@ -32,40 +37,7 @@ func (c *releaseServiceImpl) ListReleases(ctx context.Context) ([]domain.Release
 	}
 	var releases []domain.Release
 	for _, item := range r.Releases {
-		firstDeployed, _ := ptypes.Timestamp(item.Info.FirstDeployed)
-		lastDeployed, _ := ptypes.Timestamp(item.Info.LastDeployed)
-		release := domain.Release{
-			Name:      item.Name,
-			Namespace: item.Namespace,
-			Version:   int(item.Version),
-			Info: &domain.ReleaseInfo{
-				Status: &domain.ReleaseStatus{
-					Status:    item.Info.Status.GetCode().String(),
-					Resources: item.Info.Status.GetResources(),
-					Notes:     item.Info.Status.GetNotes(),
-				},
-				FirstDeployed: firstDeployed,
-				LastDeployed:  lastDeployed,
-				Description:   item.Info.Description,
-			},
-			Chart: &domain.ReleaseChart{
-				Metadata: &domain.ReleaseChartMetadata{
-					Name:        item.Chart.Metadata.Name,
-					Home:        item.Chart.Metadata.Home,
-					Version:     item.Chart.Metadata.Version,
-					Description: item.Chart.Metadata.Description,
-					Keywords:    item.Chart.Metadata.Keywords,
-					Icon:        item.Chart.Metadata.Icon,
-					APIVersion:  item.Chart.Metadata.ApiVersion,
-					Tags:        item.Chart.Metadata.Tags,
-					AppVersion:  item.Chart.Metadata.AppVersion,
-					Deprecated:  item.Chart.Metadata.Deprecated,
-					Annotations: item.Chart.Metadata.Annotations,
-					KubeVersion: item.Chart.Metadata.KubeVersion,
-				},
-			},
-		}
-		releases = append(releases, release)
+		releases = append(releases, convertRelease(item))
 	}
 	sort.Slice(releases, func(i, j int) bool {
 		return releases[i].Info.LastDeployed.After(releases[j].Info.LastDeployed)
@@ -73,6 +45,55 @@ func (c *releaseServiceImpl) ListReleases(ctx context.Context) ([]domain.Release
 	return releases, err
 }
 
+//GetRelease returns the latest revision of the release with its manifest and values
+func (c *releaseServiceImpl) GetRelease(ctx context.Context, name string) (*domain.Release, error) {
+	logger := logging.FromContext(ctx)
+	logger.WithField("release", name).Debug("getting release")
+
+	r, err := c.HelmService.ReleaseContent(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	item := r.GetRelease()
+	if item == nil {
+		return nil, nil
+	}
+	result := convertRelease(item)
+	result.Manifest = &item.Manifest
+	result.Config = &domain.ReleaseChartConfig{Raw: item.GetConfig().GetRaw()}
+	result.Chart.Values = &domain.ReleaseChartValues{Raw: item.GetChart().GetValues().GetRaw()}
+
+	values, err := chartutil.CoalesceValues(item.Chart, item.Config)
+	if err != nil {
+		return nil, err
+	}
+	computed, err := values.YAML()
+	if err != nil {
+		return nil, err
+	}
+	result.ComputedValues = &domain.ReleaseChartValues{Raw: computed}
+	return &result, nil
+}
+
+//ReleaseHistory returns all revisions of the release, newest first
+func (c *releaseServiceImpl) ReleaseHistory(ctx context.Context, name string) ([]domain.Release, error) {
+	logger := logging.FromContext(ctx)
+	logger.WithField("release", name).Debug("getting release history")
+
+	r, err := c.HelmService.ReleaseHistory(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	releases := make([]domain.Release, 0, len(r.Releases))
+	for _, item := range r.Releases {
+		releases = append(releases, convertRelease(item))
+	}
+	sort.Slice(releases, func(i, j int) bool {
+		return releases[i].Version > releases[j].Version
+	})
+	return releases, nil
+}
+
 //UpdateRelease updates helm release
 func (c *releaseServiceImpl) UpdateRelease(ctx context.Context, r *domain.ReleaseUpdateRequest) error {
 	logger := logging.FromContext(ctx)
@@ -81,8 +102,115 @@ func (c *releaseServiceImpl) UpdateRelease(ctx context.Context, r *domain.Releas
 	if err != nil {
 		return err
 	}
+	defer response.Body.Close()
 
-	_ = response.Body.Close()
 	_, err = c.HelmService.UpdateRelease(ctx, r.Name, response.Body, []byte(r.Values))
 	return err
 }
+
+//DryRunUpdateRelease renders helm release update and compares it with the deployed release
+func (c *releaseServiceImpl) DryRunUpdateRelease(ctx context.Context, r *domain.ReleaseUpdateRequest) (*domain.ReleaseDiff, error) {
+	logger := logging.FromContext(ctx)
+	logger.WithField("release", r.Name).Debug("dry running release update")
+	response, err := http.Get(r.ChartURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	rls, err := c.HelmService.DryRunUpdateRelease(ctx, r.Name, response.Body, []byte(r.Values))
+	if err != nil {
+		return nil, err
+	}
+	return diffRelease(ctx, c.HelmService, rls.GetRelease())
+}
+
+//RollbackRelease rolls back helm release with upgrade options of the webhook deploying it
+func (c *releaseServiceImpl) RollbackRelease(ctx context.Context, r *domain.ReleaseRollbackRequest) error {
+	logger := logging.FromContext(ctx)
+	logger.WithField("release", r.Name).Debug("rolling back release")
+	options, err := c.releaseUpgradeOptions(r.Name)
+	if err != nil {
+		return err
+	}
+	_, err = c.HelmService.RollbackRelease(ctx, r.Name, r.Version, options)
+	return err
+}
+
+//releaseUpgradeOptions returns upgrade options of the first webhook deploying the release,
+//nil if the release is not deployed by webhooks or the webhook has no upgrade options
+func (c *releaseServiceImpl) releaseUpgradeOptions(name string) (*domain.UpgradeOptions, error) {
+	webhooks, err := c.WebhookService.FindAll()
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range webhooks {
+		if w.DeployConfig.ReleaseName == name {
+			return w.DeployConfig.UpgradeOptions, nil
+		}
+	}
+	return nil, nil
+}
+
+//DeleteRelease deletes helm release unless it is still deployed by webhooks or force is set
+func (c *releaseServiceImpl) DeleteRelease(ctx context.Context, r *domain.ReleaseDeleteRequest) error {
+	logger := logging.FromContext(ctx)
+	if !r.Force {
+		webhooks, err := c.WebhookService.FindAll()
+		if err != nil {
+			return err
+		}
+		var names []string
+		for _, w := range webhooks {
+			if w.DeployConfig.ReleaseName == r.Name {
+				names = append(names, w.Name)
+			}
+		}
+		if len(names) > 0 {
+			return &domain.ReleaseInUseError{Release: r.Name, Webhooks: names}
+		}
+	}
+	logger.WithFields(log.Fields{
+		"release": r.Name,
+		"purge":   r.Purge,
+	}).Debug("deleting release")
+	_, err := c.HelmService.DeleteRelease(ctx, r.Name, r.Purge)
+	return err
+}
+
+//convertRelease converts Helm release into Release without manifest and values
+func convertRelease(item *release.Release) domain.Release {
+	firstDeployed, _ := ptypes.Timestamp(item.Info.FirstDeployed)
+	lastDeployed, _ := ptypes.Timestamp(item.Info.LastDeployed)
+	return domain.Release{
+		Name:      item.Name,
+		Namespace: item.Namespace,
+		Version:   int(item.Version),
+		Info: &domain.ReleaseInfo{
+			Status: &domain.ReleaseStatus{
+				Status:    item.Info.Status.GetCode().String(),
+				Resources: item.Info.Status.GetResources(),
+				Notes:     item.Info.Status.GetNotes(),
+			},
+			FirstDeployed: firstDeployed,
+			LastDeployed:  lastDeployed,
+			Description:   item.Info.Description,
+		},
+		Chart: &domain.ReleaseChart{
+			Metadata: &domain.ReleaseChartMetadata{
+				Name:        item.Chart.Metadata.Name,
+				Home:        item.Chart.Metadata.Home,
+				Version:     item.Chart.Metadata.Version,
+				Description: item.Chart.Metadata.Description,
+				Keywords:    item.Chart.Metadata.Keywords,
+				Icon:        item.Chart.Metadata.Icon,
+				APIVersion:  item.Chart.Metadata.ApiVersion,
+				Tags:        item.Chart.Metadata.Tags,
+				AppVersion:  item.Chart.Metadata.AppVersion,
+				Deprecated:  item.Chart.Metadata.Deprecated,
+				Annotations: item.Chart.Metadata.Annotations,
+				KubeVersion: item.Chart.Metadata.KubeVersion,
+			},
+		},
+	}
+}
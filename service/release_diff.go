@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/helm/pkg/proto/hapi/release"
+)
+
+const diffContextLines = 3
+
+//diffRelease compares the manifest of a dry-run release with the manifest of the deployed revision
+func diffRelease(ctx context.Context, helmService domain.HelmService, rls *release.Release) (*domain.ReleaseDiff, error) {
+	result := &domain.ReleaseDiff{
+		Name:         rls.GetName(),
+		ChartVersion: rls.GetChart().GetMetadata().GetVersion(),
+	}
+	var current string
+	// the first revision is a fresh install, there is nothing to compare with
+	if rls.GetVersion() > 1 {
+		history, err := helmService.ReleaseHistory(ctx, rls.GetName())
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range history.GetReleases() {
+			if item.GetInfo().GetStatus().GetCode() == release.Status_DEPLOYED {
+				current = item.GetManifest()
+				result.Revision = int(item.GetVersion())
+				break
+			}
+		}
+	}
+
+	var err error
+
+	result.Diff, err = difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(rls.GetManifest()),
+		FromFile: fmt.Sprintf("%s (revision %d)", result.Name, result.Revision),
+		ToFile:   fmt.Sprintf("%s (dry run)", result.Name),
+		Context:  diffContextLines,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/helm/pkg/proto/hapi/services"
+)
+
+//fakeHelmService records rollbacks
+type fakeHelmService struct {
+	domain.HelmService
+	rollbackOptions []*domain.UpgradeOptions
+}
+
+func (s *fakeHelmService) RollbackRelease(ctx context.Context, rlsName string, version int32, options *domain.UpgradeOptions) (*services.RollbackReleaseResponse, error) {
+	s.rollbackOptions = append(s.rollbackOptions, options)
+	return &services.RollbackReleaseResponse{}, nil
+}
+
+func TestRollbackReleaseUsesWebhookUpgradeOptions(t *testing.T) {
+	options := &domain.UpgradeOptions{Wait: true, Timeout: 600}
+	webhookService := &fakeWebhookService{webhooks: []domain.Webhook{
+		{Name: "api", DeployConfig: domain.DeployConfig{ReleaseName: "api"}},
+		{Name: "app", DeployConfig: domain.DeployConfig{ReleaseName: "app", UpgradeOptions: options}},
+	}}
+	tests := []struct {
+		name    string
+		release string
+		want    *domain.UpgradeOptions
+	}{
+		{name: "webhook upgrade options", release: "app", want: options},
+		{name: "webhook without upgrade options", release: "api"},
+		{name: "release without webhook", release: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			helmService := new(fakeHelmService)
+			s := NewReleaseService(helmService, webhookService)
+			if err := s.RollbackRelease(context.Background(), &domain.ReleaseRollbackRequest{Name: tt.release}); err != nil {
+				t.Fatal(err)
+			}
+			if len(helmService.rollbackOptions) != 1 || helmService.rollbackOptions[0] != tt.want {
+				t.Errorf("RollbackRelease() options = %v, want %v", helmService.rollbackOptions, tt.want)
+			}
+		})
+	}
+}
+
+func TestReleaseNotFound(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		notFound bool
+	}{
+		{name: "no error"},
+		{name: "missing release", err: status.Error(codes.Unknown, `release: "app" not found`), notFound: true},
+		{name: "release without revisions", err: status.Error(codes.Unknown, `no revision for release "app"`), notFound: true},
+		{name: "other release", err: status.Error(codes.Unknown, `release: "app-db" not found`)},
+		{name: "connection error", err: status.Error(codes.Unavailable, "transport is closing")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := releaseNotFound(tt.err, "app")
+			if _, ok := err.(*domain.ReleaseNotFoundError); ok != tt.notFound {
+				t.Errorf("releaseNotFound() = %v, want ReleaseNotFoundError %v", err, tt.notFound)
+			}
+			if !tt.notFound && err != tt.err {
+				t.Errorf("releaseNotFound() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestHelm3ReleaseNotFound(t *testing.T) {
+	s := &helm3ServiceImpl{storage: &helm3ReleaseStorage{client: newFakeKubeClient()}}
+	if _, err := s.ReleaseContent(context.Background(), "app"); !isReleaseNotFound(err) {
+		t.Errorf("ReleaseContent() error = %v, want ReleaseNotFoundError", err)
+	}
+	if _, err := s.ReleaseHistory(context.Background(), "app"); !isReleaseNotFound(err) {
+		t.Errorf("ReleaseHistory() error = %v, want ReleaseNotFoundError", err)
+	}
+}
+
+func isReleaseNotFound(err error) bool {
+	_, ok := err.(*domain.ReleaseNotFoundError)
+	return ok
+}
@@ -1,21 +1,27 @@
 package service
 
 import (
+	"fmt"
 	"time"
 
+	"github.com/entwico/helm-deployer/conf"
 	"github.com/entwico/helm-deployer/domain"
+	"github.com/globalsign/mgo/bson"
 	"github.com/pkg/errors"
 )
 
 //WebhookServiceImpl is an implementation of the WebhookService interface
 type WebhookServiceImpl struct {
 	Repository domain.WebhookRepository
+	// HelmBackend deploy configs are validated for
+	HelmBackend string
 }
 
 //NewWebhookService returns a new instance of WebhookService
-func NewWebhookService(repository domain.WebhookRepository) domain.WebhookService {
+func NewWebhookService(repository domain.WebhookRepository, helmBackend string) domain.WebhookService {
 	return &WebhookServiceImpl{
-		Repository: repository,
+		Repository:  repository,
+		HelmBackend: helmBackend,
 	}
 }
 
@@ -29,9 +35,26 @@ func (c *WebhookServiceImpl) FindOne(id string) (*domain.Webhook, error) {
 	return c.Repository.FindOne(id)
 }
 
+//FindByName returns Webhook by its name
+func (c *WebhookServiceImpl) FindByName(name string) (*domain.Webhook, error) {
+	return c.Repository.FindByName(name)
+}
+
 //Create creates new Webhook
 func (c *WebhookServiceImpl) Create(item *domain.Webhook) (*domain.Webhook, error) {
 	item.ID = ""
+	if err := validateSource(item.Source); err != nil {
+		return nil, err
+	}
+	if err := validateCondition(item.Condition); err != nil {
+		return nil, err
+	}
+	if err := validateDeployConfig(item.DeployConfig, c.HelmBackend); err != nil {
+		return nil, err
+	}
+	if err := c.checkNameUnique(item.Name, ""); err != nil {
+		return nil, err
+	}
 	return c.Repository.Save(item)
 }
 
@@ -44,9 +67,26 @@ func (c *WebhookServiceImpl) Update(id string, newItem *domain.Webhook) (*domain
 	if item == nil {
 		return nil, errors.New("item not found")
 	}
+	if err := validateSource(newItem.Source); err != nil {
+		return nil, err
+	}
+	if err := validateCondition(newItem.Condition); err != nil {
+		return nil, err
+	}
+	if err := validateDeployConfig(newItem.DeployConfig, c.HelmBackend); err != nil {
+		return nil, err
+	}
+	if err := c.checkNameUnique(newItem.Name, item.ID); err != nil {
+		return nil, err
+	}
 
 	item.Name = newItem.Name
 	item.Description = newItem.Description
+	// secrets are not returned by the API, so an empty secret keeps the stored one
+	if newItem.Secret != "" {
+		item.Secret = newItem.Secret
+	}
+	item.Source = newItem.Source
 	item.Condition = newItem.Condition
 	item.DeployConfig = newItem.DeployConfig
 	item.UpdatedAt = time.Now()
@@ -57,3 +97,53 @@ func (c *WebhookServiceImpl) Update(id string, newItem *domain.Webhook) (*domain
 func (c *WebhookServiceImpl) Delete(id string) error {
 	return c.Repository.Delete(id)
 }
+
+//checkNameUnique returns WebhookNameInUseError if a Webhook other than the one with id has the name,
+//webhooks are looked up by name on their named route
+func (c *WebhookServiceImpl) checkNameUnique(name string, id bson.ObjectId) error {
+	if name == "" {
+		return nil
+	}
+	other, err := c.Repository.FindByName(name)
+	if err != nil {
+		return err
+	}
+	if other != nil && other.ID != id {
+		return &domain.WebhookNameInUseError{Name: name}
+	}
+	return nil
+}
+
+//validateSource checks that webhooks sent to the named route of the Webhook can be processed
+func validateSource(source string) error {
+	switch source {
+	case "", domain.TriggerGithub, domain.TriggerGitlab, domain.TriggerHarbor, domain.TriggerNexus, domain.TriggerRegistry:
+		return nil
+	}
+	return fmt.Errorf("webhook source '%s' not supported", source)
+}
+
+//validateDeployConfig checks that upgrade and test options are valid and supported by the helm backend.
+//The helm3 backend does not run chart hooks, DeployConfigs have to disable them explicitly
+func validateDeployConfig(cfg domain.DeployConfig, helmBackend string) error {
+	opts := cfg.GetUpgradeOptions()
+	if opts.ResetValues && opts.ReuseValues {
+		return errors.New("resetValues and reuseValues upgrade options are mutually exclusive")
+	}
+	if opts.Timeout < 0 {
+		return errors.New("upgrade timeout must not be negative")
+	}
+	if cfg.Tests != nil && cfg.Tests.Timeout < 0 {
+		return errors.New("test timeout must not be negative")
+	}
+	if helmBackend == conf.HelmBackendHelm3 {
+		if cfg.Tests != nil {
+			return errors.New("release tests are not supported by the helm3 backend")
+		}
+		if !opts.DisableHooks {
+			return errors.New("chart hooks are not run by the helm3 backend, the disableHooks upgrade option must be set")
+		}
+		return validateHelm3UpgradeOptions(opts)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/gobwas/glob"
+)
+
+const globSeparator = '/'
+
+type matcher func(value string) bool
+
+//matchCondition returns true if the condition received from an event satisfies the webhook condition
+func matchCondition(webhookCond, eventCond domain.GitlabWebhookCondition) (bool, error) {
+	if webhookCond.IsTag != eventCond.IsTag {
+		return false, nil
+	}
+	if webhookCond.WebhookType != eventCond.WebhookType && !(isPatternMode(webhookCond.MatchMode) && webhookCond.WebhookType == "") {
+		return false, nil
+	}
+	fields := []struct{ pattern, value string }{
+		{webhookCond.ProjectName, eventCond.ProjectName},
+		{webhookCond.ProjectNamespace, eventCond.ProjectNamespace},
+		{webhookCond.GitRef, eventCond.GitRef},
+	}
+	for _, f := range fields {
+		match, err := compilePattern(webhookCond.MatchMode, f.pattern)
+		if err != nil {
+			return false, err
+		}
+		if !match(f.value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+//validateCondition checks that all condition patterns can be compiled
+func validateCondition(cond domain.GitlabWebhookCondition) error {
+	for _, pattern := range []string{cond.ProjectName, cond.ProjectNamespace, cond.GitRef} {
+		if _, err := compilePattern(cond.MatchMode, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//isPatternMode returns true if condition fields of the mode are patterns, empty patterns match any value
+func isPatternMode(mode string) bool {
+	return mode == domain.MatchModeGlob || mode == domain.MatchModeRegex
+}
+
+//compilePattern returns matcher of the pattern. Empty patterns match any value in glob and regex modes,
+//exact mode requires equal values
+func compilePattern(mode, pattern string) (matcher, error) {
+	if pattern == "" && isPatternMode(mode) {
+		return func(string) bool { return true }, nil
+	}
+	switch mode {
+	case "", domain.MatchModeExact:
+		return func(value string) bool { return value == pattern }, nil
+	case domain.MatchModeGlob:
+		g, err := glob.Compile(pattern, globSeparator)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob pattern '%s': %v", pattern, err)
+		}
+		return g.Match, nil
+	case domain.MatchModeRegex:
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression '%s': %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+	return nil, fmt.Errorf("match mode '%s' not supported", mode)
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestMatchCondition(t *testing.T) {
+	event := domain.GitlabWebhookCondition{
+		WebhookType:      "pipeline",
+		ProjectName:      "backend",
+		ProjectNamespace: "team",
+		GitRef:           "release/1.2",
+	}
+	tag := event
+	tag.GitRef = "v1.2.0"
+	tag.IsTag = true
+
+	tests := []struct {
+		name    string
+		webhook domain.GitlabWebhookCondition
+		event   domain.GitlabWebhookCondition
+		want    bool
+	}{
+		{name: "exact", webhook: event, event: event, want: true},
+		{name: "legacy mode is exact", webhook: domain.GitlabWebhookCondition{WebhookType: "pipeline", ProjectName: "backend",
+			ProjectNamespace: "team", GitRef: "release/1.2"}, event: event, want: true},
+		{name: "exact ref mismatch", webhook: withRef(event, "release/1.3"), event: event, want: false},
+		{name: "exact empty ref does not match any", webhook: withRef(event, ""), event: event, want: false},
+		{name: "empty webhook type does not match any", webhook: withType(event, ""), event: event, want: false},
+		{name: "other webhook type", webhook: withType(event, "push"), event: event, want: false},
+		{name: "tag flag differs", webhook: event, event: withTag(event), want: false},
+		{name: "glob branch", webhook: withMode(withRef(event, "release/*"), domain.MatchModeGlob), event: event, want: true},
+		{name: "glob does not cross separator", webhook: withMode(withRef(event, "*"), domain.MatchModeGlob), event: event, want: false},
+		{name: "glob any ref", webhook: withMode(withRef(event, "**"), domain.MatchModeGlob), event: event, want: true},
+		{name: "glob empty ref matches any", webhook: withMode(withRef(event, ""), domain.MatchModeGlob), event: event, want: true},
+		{name: "glob empty webhook type matches any", webhook: withMode(withType(event, ""), domain.MatchModeGlob), event: event, want: true},
+		{name: "glob only ref", webhook: withMode(domain.GitlabWebhookCondition{GitRef: "release/*"}, domain.MatchModeGlob), event: event, want: true},
+		{name: "glob only ref of other branch", webhook: withMode(domain.GitlabWebhookCondition{GitRef: "feature/*"}, domain.MatchModeGlob),
+			event: event, want: false},
+		{name: "glob tag", webhook: withTag(withMode(withRef(event, "v*"), domain.MatchModeGlob)), event: tag, want: true},
+		{name: "glob project", webhook: withMode(domain.GitlabWebhookCondition{WebhookType: "pipeline", ProjectName: "back*",
+			ProjectNamespace: "*", GitRef: "**"}, domain.MatchModeGlob), event: event, want: true},
+		{name: "regex tag", webhook: withTag(withMode(withRef(event, `v\d+\.\d+\.\d+`), domain.MatchModeRegex)), event: tag, want: true},
+		{name: "regex matches whole value", webhook: withTag(withMode(withRef(event, `v\d+`), domain.MatchModeRegex)), event: tag, want: false},
+		{name: "regex any ref", webhook: withMode(withRef(event, ".*"), domain.MatchModeRegex), event: event, want: true},
+		{name: "regex empty ref matches any", webhook: withMode(withRef(event, ""), domain.MatchModeRegex), event: event, want: true},
+		{name: "regex only tag ref", webhook: withTag(withMode(domain.GitlabWebhookCondition{GitRef: `v\d+\..*`}, domain.MatchModeRegex)),
+			event: tag, want: true},
+		{name: "regex empty webhook type matches any", webhook: withMode(withType(event, ""), domain.MatchModeRegex), event: event, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := matchCondition(tt.webhook, tt.event)
+			if err != nil {
+				t.Fatalf("matchCondition() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("matchCondition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		cond    domain.GitlabWebhookCondition
+		wantErr bool
+	}{
+		{name: "exact", cond: domain.GitlabWebhookCondition{GitRef: "[master"}},
+		{name: "glob", cond: withMode(domain.GitlabWebhookCondition{GitRef: "release/*"}, domain.MatchModeGlob)},
+		{name: "invalid glob", cond: withMode(domain.GitlabWebhookCondition{GitRef: "[master"}, domain.MatchModeGlob), wantErr: true},
+		{name: "invalid regex", cond: withMode(domain.GitlabWebhookCondition{ProjectName: "(backend"}, domain.MatchModeRegex), wantErr: true},
+		{name: "unknown mode", cond: withMode(domain.GitlabWebhookCondition{}, "fuzzy"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCondition(tt.cond); (err != nil) != tt.wantErr {
+				t.Errorf("validateCondition() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func withRef(cond domain.GitlabWebhookCondition, ref string) domain.GitlabWebhookCondition {
+	cond.GitRef = ref
+	return cond
+}
+
+func withType(cond domain.GitlabWebhookCondition, webhookType string) domain.GitlabWebhookCondition {
+	cond.WebhookType = webhookType
+	return cond
+}
+
+func withMode(cond domain.GitlabWebhookCondition, mode string) domain.GitlabWebhookCondition {
+	cond.MatchMode = mode
+	return cond
+}
+
+func withTag(cond domain.GitlabWebhookCondition) domain.GitlabWebhookCondition {
+	cond.IsTag = true
+	return cond
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/entwico/helm-deployer/domain"
+	"github.com/pkg/errors"
+)
+
+//ErrInvalidSignature is returned when webhook could not be verified with any known secret
+var ErrInvalidSignature = errors.New("webhook signature is not valid")
+
+//ErrNoWebhookSecret is returned when no secret applies to the webhook, unverified webhooks are never accepted
+var ErrNoWebhookSecret = errors.New("no webhook secret configured")
+
+//getWebhookSecrets returns the secret of the Webhook attached to the context,
+//webhooks sent to the global route are verified with the configured secret only
+func getWebhookSecrets(ctx context.Context, configSecret string) []string {
+	secret := configSecret
+	if w := domain.WebhookFromContext(ctx); w != nil {
+		secret = w.Secret
+	}
+	if secret == "" {
+		return nil
+	}
+	return []string{secret}
+}
+
+//verifyWithSecrets succeeds if check passes for any of the secrets, it fails if no secrets are configured
+func verifyWithSecrets(secrets []string, check func(secret string) bool) error {
+	if len(secrets) == 0 {
+		return ErrNoWebhookSecret
+	}
+	for _, secret := range secrets {
+		if check(secret) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+//checkHMACSignature compares hex encoded HMAC of the body with the signature
+func checkHMACSignature(hashFunc func() hash.Hash, secret string, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(hashFunc, []byte(secret))
+	_, _ = mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+//checkToken compares token with the secret in constant time
+func checkToken(secret, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(token)) == 1
+}
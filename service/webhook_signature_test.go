@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"reflect"
+	"testing"
+
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestVerifyWithSecrets(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		token   string
+		err     error
+	}{
+		{name: "no secrets", secrets: nil, token: "", err: ErrNoWebhookSecret},
+		{name: "no secrets with token", secrets: []string{}, token: "secret", err: ErrNoWebhookSecret},
+		{name: "matching secret", secrets: []string{"secret"}, token: "secret", err: nil},
+		{name: "second secret matches", secrets: []string{"other", "secret"}, token: "secret", err: nil},
+		{name: "wrong token", secrets: []string{"secret"}, token: "wrong", err: ErrInvalidSignature},
+		{name: "empty token", secrets: []string{"secret"}, token: "", err: ErrInvalidSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyWithSecrets(tt.secrets, func(secret string) bool {
+				return checkToken(secret, tt.token)
+			})
+			if err != tt.err {
+				t.Errorf("verifyWithSecrets() = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckHMACSignature(t *testing.T) {
+	body := []byte(`{"ref":"refs/heads/master"}`)
+	sign := func(hashFunc func() hash.Hash, secret string) string {
+		mac := hmac.New(hashFunc, []byte(secret))
+		_, _ = mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	tests := []struct {
+		name      string
+		hashFunc  func() hash.Hash
+		signature string
+		want      bool
+	}{
+		{name: "sha256", hashFunc: sha256.New, signature: sign(sha256.New, "secret"), want: true},
+		{name: "sha1", hashFunc: sha1.New, signature: sign(sha1.New, "secret"), want: true},
+		{name: "surrounding spaces", hashFunc: sha256.New, signature: " " + sign(sha256.New, "secret") + "\n", want: true},
+		{name: "wrong secret", hashFunc: sha256.New, signature: sign(sha256.New, "other"), want: false},
+		{name: "wrong hash", hashFunc: sha256.New, signature: sign(sha1.New, "secret"), want: false},
+		{name: "not hex", hashFunc: sha256.New, signature: "not-a-signature", want: false},
+		{name: "empty", hashFunc: sha256.New, signature: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := checkHMACSignature(tt.hashFunc, "secret", body, tt.signature); got != tt.want {
+				t.Errorf("checkHMACSignature() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetWebhookSecrets(t *testing.T) {
+	tests := []struct {
+		name         string
+		webhook      *domain.Webhook
+		configSecret string
+		want         []string
+	}{
+		{name: "global route uses configured secret", configSecret: "global", want: []string{"global"}},
+		{name: "global route without configured secret", want: nil},
+		{name: "named route uses webhook secret", webhook: &domain.Webhook{Secret: "team"}, configSecret: "global", want: []string{"team"}},
+		{name: "named route without webhook secret", webhook: &domain.Webhook{}, configSecret: "global", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.webhook != nil {
+				ctx = domain.NewContextWithWebhook(ctx, tt.webhook)
+			}
+			if got := getWebhookSecrets(ctx, tt.configSecret); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getWebhookSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/bbolt"
+	"github.com/entwico/helm-deployer/conf"
+	"github.com/entwico/helm-deployer/domain"
+)
+
+func TestValidateDeployConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         domain.DeployConfig
+		helmBackend string
+		wantErr     bool
+	}{
+		{name: "defaults", helmBackend: conf.HelmBackendTiller},
+		{name: "defaults on helm3 run hooks", helmBackend: conf.HelmBackendHelm3, wantErr: true},
+		{name: "reset and reuse values", cfg: domain.DeployConfig{UpgradeOptions: &domain.UpgradeOptions{ResetValues: true, ReuseValues: true}},
+			helmBackend: conf.HelmBackendTiller, wantErr: true},
+		{name: "tests", cfg: domain.DeployConfig{Tests: &domain.TestOptions{}}, helmBackend: conf.HelmBackendTiller},
+		{name: "tests on helm3", cfg: domain.DeployConfig{Tests: &domain.TestOptions{}}, helmBackend: conf.HelmBackendHelm3, wantErr: true},
+		{name: "wait", cfg: domain.DeployConfig{UpgradeOptions: &domain.UpgradeOptions{Wait: true}}, helmBackend: conf.HelmBackendTiller},
+		{name: "wait on helm3", cfg: domain.DeployConfig{UpgradeOptions: &domain.UpgradeOptions{Wait: true, DisableHooks: true}}, helmBackend: conf.HelmBackendHelm3, wantErr: true},
+		{name: "hooks on helm3", cfg: domain.DeployConfig{UpgradeOptions: &domain.UpgradeOptions{Force: true}}, helmBackend: conf.HelmBackendHelm3, wantErr: true},
+		{name: "disabled hooks on helm3", cfg: domain.DeployConfig{UpgradeOptions: &domain.UpgradeOptions{Force: true, Recreate: true, DisableHooks: true}},
+			helmBackend: conf.HelmBackendHelm3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateDeployConfig(tt.cfg, tt.helmBackend); (err != nil) != tt.wantErr {
+				t.Errorf("validateDeployConfig() error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func newTestWebhookService(t *testing.T) domain.WebhookService {
+	dir, err := ioutil.TempDir("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	})
+	repository, err := NewWebhookRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewWebhookService(repository, conf.HelmBackendTiller)
+}
+
+func TestWebhookServiceNameInUse(t *testing.T) {
+	s := newTestWebhookService(t)
+	app, err := s.Create(&domain.Webhook{Name: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	api, err := s.Create(&domain.Webhook{Name: "api"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := s.Create(&domain.Webhook{}); err != nil {
+			t.Errorf("Create() of a webhook without name = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		save    func() error
+		wantErr bool
+	}{
+		{name: "create with used name", save: func() error {
+			_, err := s.Create(&domain.Webhook{Name: "app"})
+			return err
+		}, wantErr: true},
+		{name: "rename to used name", save: func() error {
+			_, err := s.Update(api.ID.Hex(), &domain.Webhook{Name: "app"})
+			return err
+		}, wantErr: true},
+		{name: "update keeping name", save: func() error {
+			_, err := s.Update(app.ID.Hex(), &domain.Webhook{Name: "app", Description: "frontend"})
+			return err
+		}},
+		{name: "rename to free name", save: func() error {
+			_, err := s.Update(api.ID.Hex(), &domain.Webhook{Name: "backend"})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.save()
+			if _, ok := err.(*domain.WebhookNameInUseError); ok != tt.wantErr {
+				t.Errorf("save error = %v, want WebhookNameInUseError %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("save error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWebhookServiceUpgradeOptions(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     *domain.UpgradeOptions
+		want        domain.UpgradeOptions
+		wantTimeout int64
+		wantErr     bool
+	}{
+		{name: "defaults", want: domain.DefaultUpgradeOptions, wantTimeout: defaultHelmTimeout},
+		{name: "rolling upgrade", options: &domain.UpgradeOptions{}, wantTimeout: defaultHelmTimeout},
+		{
+			name:        "wait with timeout",
+			options:     &domain.UpgradeOptions{Wait: true, Timeout: 600, DisableHooks: true},
+			want:        domain.UpgradeOptions{Wait: true, Timeout: 600, DisableHooks: true},
+			wantTimeout: 600,
+		},
+		{name: "reuse values", options: &domain.UpgradeOptions{ReuseValues: true}, want: domain.UpgradeOptions{ReuseValues: true}, wantTimeout: defaultHelmTimeout},
+		{name: "reset and reuse values", options: &domain.UpgradeOptions{ResetValues: true, ReuseValues: true}, wantErr: true},
+		{name: "negative timeout", options: &domain.UpgradeOptions{Timeout: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestWebhookService(t)
+			created, err := s.Create(&domain.Webhook{Name: "app", DeployConfig: domain.DeployConfig{ReleaseName: "app", UpgradeOptions: tt.options}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Create() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			stored, err := s.FindOne(created.ID.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := stored.DeployConfig.GetUpgradeOptions()
+			if opts != tt.want {
+				t.Errorf("stored upgrade options = %+v, want %+v", opts, tt.want)
+			}
+			if timeout := helmTimeout(opts); timeout != tt.wantTimeout {
+				t.Errorf("helmTimeout() = %d, want %d", timeout, tt.wantTimeout)
+			}
+		})
+	}
+}